@@ -176,6 +176,7 @@ async function uploadVideoFile(videoID) {
     }
 
     console.log('Video uploaded!');
+    await waitForProcessing(videoID, uploadBtnSelector);
     await getVideo(videoID);
   } catch (error) {
     alert(`Error: ${error.message}`);
@@ -184,11 +185,44 @@ async function uploadVideoFile(videoID) {
   setUploadButtonState(false, uploadBtnSelector);
 }
 
+async function waitForProcessing(videoID, selector) {
+  const uploadBtn = document.getElementById(selector);
+  for (;;) {
+    const res = await fetch(`/api/videos/${videoID}/processing`, {
+      method: 'GET',
+      headers: {
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+      },
+    });
+    const job = await res.json();
+    if (!res.ok) {
+      throw new Error(`Failed to get processing status. Error: ${job.error}`);
+    }
+    if (job.state === 'ready') {
+      return;
+    }
+    if (job.state === 'failed') {
+      throw new Error(`Video processing failed: ${job.error}`);
+    }
+    uploadBtn.textContent = `${job.state.charAt(0).toUpperCase()}${job.state.slice(1)}...`;
+    await new Promise((resolve) => setTimeout(resolve, 2000));
+  }
+}
+
 const videoStateHandler = createVideoStateHandler();
 
-async function getVideos() {
+let nextVideosCursor = null;
+
+// getVideos lists the first page of videos, or with a cursor appends the
+// page after it.
+async function getVideos(cursor = null) {
+  const params = new URLSearchParams();
+  if (cursor) {
+    params.set('cursor', cursor);
+  }
+
   try {
-    const res = await fetch('/api/videos', {
+    const res = await fetch(`/api/videos?${params}`, {
       method: 'GET',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -199,20 +233,31 @@ async function getVideos() {
       throw new Error(`Failed to get videos. Error: ${data.error}`);
     }
 
-    const videos = await res.json();
+    const page = await res.json();
     const videoList = document.getElementById('video-list');
-    videoList.innerHTML = '';
-    for (const video of videos) {
+    if (!cursor) {
+      videoList.innerHTML = '';
+    }
+    for (const video of page.videos) {
       const listItem = document.createElement('li');
       listItem.textContent = video.title;
       listItem.onclick = () => videoStateHandler(video.id);
       videoList.appendChild(listItem);
     }
+
+    nextVideosCursor = page.next_cursor;
+    document.getElementById('load-more-videos').style.display = nextVideosCursor ? 'block' : 'none';
   } catch (error) {
     alert(`Error: ${error.message}`);
   }
 }
 
+async function loadMoreVideos() {
+  if (nextVideosCursor) {
+    await getVideos(nextVideosCursor);
+  }
+}
+
 function createVideoStateHandler() {
   let currentVideoID = null;
 
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+type videoOrientation string
+
+const (
+	orientationLandscape videoOrientation = "landscape"
+	orientationPortrait  videoOrientation = "portrait"
+	orientationSquare    videoOrientation = "square"
+)
+
+// Ratios within this relative distance of 1:1 count as square.
+const squareTolerance = 0.01
+
+var standardAspectRatios = []struct {
+	name  string
+	value float64
+}{
+	{"16:9", 16.0 / 9.0},
+	{"9:16", 9.0 / 16.0},
+	{"4:3", 4.0 / 3.0},
+	{"1:1", 1},
+	{"21:9", 21.0 / 9.0},
+}
+
+// aspectRatio is how a video is shown on screen: coded size corrected by
+// the sample aspect ratio and the display rotation.
+type aspectRatio struct {
+	Width       int
+	Height      int
+	Ratio       float64
+	Orientation videoOrientation
+	// Nearest is the closest of standardAspectRatios, e.g. "16:9".
+	Nearest string
+}
+
+// Prefix is the storage key prefix videos with this aspect ratio go under.
+func (a aspectRatio) Prefix() string {
+	return string(a.Orientation)
+}
+
+var errNoVideoStream = errors.New("no video stream found")
+
+// primaryVideoStream returns the index into Streams of the stream a player
+// would show. Cover art and other still images are muxed as video streams
+// too, so those are skipped, and a stream flagged as default wins.
+func primaryVideoStream(videoMetaData media.Metadata) (int, bool) {
+	primary := -1
+	for i, stream := range videoMetaData.Streams {
+		if stream.CodecType != "video" {
+			continue
+		}
+		if stream.Disposition.AttachedPic == 1 || stream.Disposition.StillImage == 1 {
+			continue
+		}
+		if stream.Width <= 0 || stream.Height <= 0 {
+			continue
+		}
+		if stream.Disposition.Default == 1 {
+			return i, true
+		}
+		if primary == -1 {
+			primary = i
+		}
+	}
+	return primary, primary != -1
+}
+
+// displaySize applies the sample aspect ratio and rotation of the stream to
+// its coded size.
+func displaySize(videoMetaData media.Metadata, index int) (width, height int) {
+	stream := videoMetaData.Streams[index]
+	width, height = stream.Width, stream.Height
+	if num, den, ok := parseRatio(stream.SampleAspectRatio); ok && num != den {
+		width = int(math.Round(float64(width) * float64(num) / float64(den)))
+	}
+	switch streamRotation(stream.Tags.Rotate, stream.SideDataList) {
+	case 90, 270:
+		width, height = height, width
+	}
+	return width, height
+}
+
+// parseRatio parses "num:den" as ffprobe prints sample and display aspect
+// ratios. "0:1" means unknown and is rejected.
+func parseRatio(ratio string) (num, den int, ok bool) {
+	numStr, denStr, found := strings.Cut(ratio, ":")
+	if !found {
+		return 0, 0, false
+	}
+	num, err := strconv.Atoi(numStr)
+	if err != nil || num <= 0 {
+		return 0, 0, false
+	}
+	den, err = strconv.Atoi(denStr)
+	if err != nil || den <= 0 {
+		return 0, 0, false
+	}
+	return num, den, true
+}
+
+func detectAspectRatio(videoMetaData media.Metadata) (aspectRatio, error) {
+	index, ok := primaryVideoStream(videoMetaData)
+	if !ok {
+		return aspectRatio{}, errNoVideoStream
+	}
+	width, height := displaySize(videoMetaData, index)
+	ratio := float64(width) / float64(height)
+
+	a := aspectRatio{Width: width, Height: height, Ratio: ratio}
+	switch {
+	case math.Abs(ratio-1) <= squareTolerance:
+		a.Orientation = orientationSquare
+	case ratio > 1:
+		a.Orientation = orientationLandscape
+	default:
+		a.Orientation = orientationPortrait
+	}
+
+	// Compare on a log scale so 2:1 and 1:2 are equally far from 1:1.
+	best := math.Inf(1)
+	for _, standard := range standardAspectRatios {
+		distance := math.Abs(math.Log(ratio / standard.value))
+		if distance < best {
+			best = distance
+			a.Nearest = standard.name
+		}
+	}
+	return a, nil
+}
+
+// aspectRatioPrefix maps an aspect ratio declared by a client before the
+// upload, either a standard ratio like "16:9" or an orientation, to a key
+// prefix. "other" parks the upload until it has been probed.
+func aspectRatioPrefix(ratio string) (string, error) {
+	switch ratio {
+	case "16:9", "4:3", "21:9", string(orientationLandscape):
+		return string(orientationLandscape), nil
+	case "9:16", string(orientationPortrait):
+		return string(orientationPortrait), nil
+	case "1:1", string(orientationSquare):
+		return string(orientationSquare), nil
+	case "other":
+		return "other", nil
+	default:
+		return "", fmt.Errorf("invalid aspect ratio %q", ratio)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+// loadProbe reads ffprobe -print_format json -show_streams -show_format
+// output saved under testdata.
+func loadProbe(t *testing.T, name string) media.Metadata {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metadata media.Metadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatal(err)
+	}
+	return metadata
+}
+
+func TestDetectAspectRatio(t *testing.T) {
+	tests := []struct {
+		fixture     string
+		width       int
+		height      int
+		orientation videoOrientation
+		nearest     string
+	}{
+		{"audio_first.json", 1920, 1080, orientationLandscape, "16:9"},
+		{"attached_pic.json", 1280, 720, orientationLandscape, "16:9"},
+		{"rotated_portrait.json", 1080, 1920, orientationPortrait, "9:16"},
+		{"anamorphic.json", 853, 480, orientationLandscape, "16:9"},
+		{"square.json", 1080, 1080, orientationSquare, "1:1"},
+		{"ultrawide.json", 2560, 1080, orientationLandscape, "21:9"},
+		{"four_three.json", 640, 480, orientationLandscape, "4:3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			got, err := detectAspectRatio(loadProbe(t, tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Width != tt.width || got.Height != tt.height {
+				t.Errorf("display size %dx%d, want %dx%d", got.Width, got.Height, tt.width, tt.height)
+			}
+			if got.Orientation != tt.orientation {
+				t.Errorf("orientation %s, want %s", got.Orientation, tt.orientation)
+			}
+			if got.Nearest != tt.nearest {
+				t.Errorf("nearest ratio %s, want %s", got.Nearest, tt.nearest)
+			}
+		})
+	}
+}
+
+func TestDetectAspectRatioNoVideo(t *testing.T) {
+	metadata := loadProbe(t, "attached_pic.json")
+	// Cover art and audio only, as in an .m4a with artwork.
+	metadata.Streams = append(metadata.Streams[:1], metadata.Streams[2])
+	_, err := detectAspectRatio(metadata)
+	if !errors.Is(err, errNoVideoStream) {
+		t.Errorf("got %v, want errNoVideoStream", err)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+const (
+	storageBackendS3     = "s3"
+	storageBackendLocal  = "local"
+	storageBackendMemory = "memory"
+)
+
+func (cfg *apiConfig) newBlobStore(backend string) (storage.BlobStore, error) {
+	switch backend {
+	case storageBackendS3:
+		if cfg.s3Client == nil {
+			return nil, fmt.Errorf("s3 storage selected but no S3 client configured")
+		}
+		return storage.NewS3Store(cfg.s3Client, cfg.s3Bucket, cfg.s3CfDistribution, cfg.s3Options), nil
+	case storageBackendLocal:
+		return storage.NewLocalStore(cfg.assetsRoot, cfg.assetsBaseURL)
+	case storageBackendMemory:
+		return storage.NewMemoryStore("memory://"), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+var streamingContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".mpd":  "application/dash+xml",
+	".mp4":  "video/mp4",
+	".vtt":  "text/vtt",
+}
+
+func contentTypeFor(name string) string {
+	ext := path.Ext(name)
+	if contentType, ok := streamingContentTypes[ext]; ok {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// putDir uploads every file below dir to store, keyed by its path relative
+// to dir under keyPrefix.
+func putDir(ctx context.Context, store storage.BlobStore, dir, keyPrefix string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return store.Put(ctx, keyPrefix+"/"+filepath.ToSlash(rel), f, contentTypeFor(p))
+	})
+}
+
+// multipartSweeper is implemented by stores that can abort multipart
+// uploads clients started and never finished, like storage.S3Store.
+type multipartSweeper interface {
+	AbortStaleMultipartUploads(ctx context.Context, maxAge time.Duration) (int, error)
+}
+
+// sweepStaleMultipartUploads periodically aborts multipart uploads to store
+// that were started more than maxAge ago and never finished.
+func (cfg *apiConfig) sweepStaleMultipartUploads(store multipartSweeper, interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		aborted, err := store.AbortStaleMultipartUploads(context.Background(), maxAge)
+		if err != nil {
+			log.Printf("Error aborting stale multipart uploads: %v", err)
+		}
+		if aborted > 0 {
+			log.Printf("Aborted %d stale multipart uploads", aborted)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+const (
+	cleanupPollEvery  = time.Minute
+	cleanupBatchSize  = 100
+	cleanupRetryAfter = 30 * time.Second
+	cleanupMaxBackoff = 6 * time.Hour
+)
+
+// videoObjectCleanup deletes a stored video and everything packaged next to
+// it: streaming renditions and preview sprites live under the key without
+// ".mp4".
+func videoObjectCleanup(key string) []database.CleanupTask {
+	return []database.CleanupTask{
+		{Store: database.CleanupStoreVideo, Key: key},
+		{Store: database.CleanupStoreVideo, Key: strings.TrimSuffix(key, ".mp4") + "/", Prefix: true},
+	}
+}
+
+func thumbnailCleanup(keys []string) []database.CleanupTask {
+	tasks := []database.CleanupTask{}
+	for _, key := range keys {
+		tasks = append(tasks, database.CleanupTask{Store: database.CleanupStoreThumbnail, Key: key})
+	}
+	return tasks
+}
+
+// videoCleanup lists the objects that go away with video. Content shared
+// through a blob isn't included; it is released separately.
+func videoCleanup(video database.Video) []database.CleanupTask {
+	tasks := thumbnailCleanup(video.ThumbnailKeys)
+	if video.ContentHash == nil && video.VideoKey != nil {
+		tasks = append(tasks, videoObjectCleanup(*video.VideoKey)...)
+	}
+	return tasks
+}
+
+// scheduleCleanup queues tasks and wakes the cleanup worker. Failing to
+// queue is only logged; the objects are then left for the garbage
+// collector.
+func (cfg *apiConfig) scheduleCleanup(tasks ...database.CleanupTask) {
+	if len(tasks) == 0 {
+		return
+	}
+	if err := cfg.cleanupTasks.CreateCleanupTasks(tasks); err != nil {
+		log.Printf("Error scheduling cleanup of %d objects: %v", len(tasks), err)
+		return
+	}
+	cfg.wakeCleanup()
+}
+
+func (cfg *apiConfig) wakeCleanup() {
+	select {
+	case cfg.cleanupWake <- struct{}{}:
+	default:
+	}
+}
+
+// acquireBlob records content storeBlob just uploaded. When the same content
+// was stored concurrently the existing blob is used and this copy deleted.
+func (cfg *apiConfig) acquireBlob(stored database.Blob) (database.Blob, error) {
+	blob, err := cfg.blobs.AcquireBlob(stored)
+	if err != nil {
+		cfg.scheduleCleanup(videoObjectCleanup(stored.Key)...)
+		return database.Blob{}, err
+	}
+	if blob.Key != stored.Key {
+		log.Printf("Content %v was stored concurrently, dropping the copy at %v", blob.Hash, stored.Key)
+		cfg.scheduleCleanup(videoObjectCleanup(stored.Key)...)
+	}
+	return blob, nil
+}
+
+// releaseBlob drops a video's reference on stored content. The objects are
+// queued for deletion once nothing references them any more.
+func (cfg *apiConfig) releaseBlob(hash string) {
+	blob, err := cfg.blobs.ReleaseBlob(hash, videoObjectCleanup)
+	if err != nil {
+		log.Printf("Error releasing video content %v: %v", hash, err)
+		return
+	}
+	if blob.RefCount == 0 {
+		cfg.wakeCleanup()
+	}
+}
+
+// retireThumbnails queues the previous thumbnail's objects for deletion
+// once a video has been given a new one.
+func (cfg *apiConfig) retireThumbnails(previous, current database.StorageKeys) {
+	keep := map[string]bool{}
+	for _, key := range current {
+		keep[key] = true
+	}
+	stale := []string{}
+	for _, key := range previous {
+		if !keep[key] {
+			stale = append(stale, key)
+		}
+	}
+	cfg.scheduleCleanup(thumbnailCleanup(stale)...)
+}
+
+func (cfg *apiConfig) cleanupStore(name string) (storage.BlobStore, error) {
+	switch name {
+	case database.CleanupStoreVideo:
+		return cfg.videoStore, nil
+	case database.CleanupStoreThumbnail:
+		return cfg.thumbnailStore, nil
+	default:
+		return nil, fmt.Errorf("unknown store %q", name)
+	}
+}
+
+func (cfg *apiConfig) runCleanupTask(ctx context.Context, task database.CleanupTask) error {
+	store, err := cfg.cleanupStore(task.Store)
+	if err != nil {
+		return err
+	}
+	keys := []string{task.Key}
+	if task.Prefix {
+		objects, err := store.List(ctx, task.Key)
+		if err != nil {
+			return fmt.Errorf("couldn't list %v: %w", task.Key, err)
+		}
+		keys = keys[:0]
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+	}
+	for _, key := range keys {
+		err := store.Delete(ctx, key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("couldn't delete %v: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// cleanupBackoff doubles the wait after every failed attempt, up to
+// cleanupMaxBackoff. Tasks are never given up on.
+func cleanupBackoff(attempts int) time.Duration {
+	backoff := cleanupRetryAfter
+	for range attempts {
+		backoff *= 2
+		if backoff >= cleanupMaxBackoff {
+			return cleanupMaxBackoff
+		}
+	}
+	return backoff
+}
+
+// runCleanupTasks runs every due task once and reports whether there may be
+// more due tasks left.
+func (cfg *apiConfig) runCleanupTasks(ctx context.Context) bool {
+	tasks, err := cfg.cleanupTasks.GetDueCleanupTasks(time.Now(), cleanupBatchSize)
+	if err != nil {
+		log.Printf("Error getting cleanup tasks: %v", err)
+		return false
+	}
+	for _, task := range tasks {
+		err := cfg.runCleanupTask(ctx, task)
+		if err != nil {
+			log.Printf("Cleanup of %s %v failed (attempt %d): %v", task.Store, task.Key, task.Attempts+1, err)
+			err = cfg.cleanupTasks.RetryCleanupTask(task.ID, err.Error(), time.Now().Add(cleanupBackoff(task.Attempts)))
+		} else {
+			err = cfg.cleanupTasks.CompleteCleanupTask(task.ID)
+		}
+		if errors.Is(err, database.ErrNotFound) {
+			// Another replica's worker ran the same task and got there first.
+			continue
+		}
+		if err != nil {
+			log.Printf("Error updating cleanup task %v: %v", task.ID, err)
+			return false
+		}
+	}
+	return len(tasks) == cleanupBatchSize
+}
+
+func (cfg *apiConfig) cleanupWorker() {
+	ticker := time.NewTicker(cleanupPollEvery)
+	defer ticker.Stop()
+	for {
+		cfg.expireVideoVersions()
+		if cfg.runCleanupTasks(context.Background()) {
+			continue
+		}
+		select {
+		case <-cfg.cleanupWake:
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// newSameContentConfig returns a config whose every upload is processed into
+// the same bytes.
+func newSameContentConfig(t *testing.T) *apiConfig {
+	fake := &media.Fake{
+		Metadata: loadProbe(t, "four_three.json"),
+		RunFunc: func(args []string, stdin io.Reader, stdout io.Writer) error {
+			return os.WriteFile(args[len(args)-1], []byte("normalized"), 0644)
+		},
+	}
+	return newTestConfig(t, fake)
+}
+
+func uploadAndProcess(t *testing.T, cfg *apiConfig, video database.Video) database.Video {
+	t.Helper()
+	w := httptest.NewRecorder()
+	cfg.handlerUploadVideo(w, newUploadRequest(t, cfg, video, mp4Header))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("upload returned %d: %s", w.Code, w.Body)
+	}
+	if job := processNextJob(t, cfg); job.State != database.ProcessingStateReady {
+		t.Fatalf("job is %s (error %v), want ready", job.State, job.Error)
+	}
+	video, err := cfg.videos.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return video
+}
+
+func TestCleanupOfReleasedContentSparesItsReupload(t *testing.T) {
+	cfg := newSameContentConfig(t)
+	ctx := context.Background()
+
+	first := uploadAndProcess(t, cfg, newTestVideo(t, cfg))
+	// The only video using the content goes; its cleanup is queued but
+	// hasn't run when the same content is uploaded again.
+	cfg.releaseBlob(*first.ContentHash)
+	second := uploadAndProcess(t, cfg, newTestVideo(t, cfg))
+
+	if *second.ContentHash != *first.ContentHash {
+		t.Fatalf("content hashes differ: %s, %s", *first.ContentHash, *second.ContentHash)
+	}
+	if *second.VideoKey == *first.VideoKey {
+		t.Fatalf("re-upload was stored under the released key %s", *first.VideoKey)
+	}
+	cfg.runCleanupTasks(ctx)
+
+	if _, err := cfg.videoStore.Head(ctx, *first.VideoKey); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("released copy wasn't deleted: %v", err)
+	}
+	if _, err := cfg.videoStore.Head(ctx, *second.VideoKey); err != nil {
+		t.Errorf("re-uploaded copy is gone: %v", err)
+	}
+}
+
+func TestReuseBlobAfterRelease(t *testing.T) {
+	cfg := newSameContentConfig(t)
+
+	video := uploadAndProcess(t, cfg, newTestVideo(t, cfg))
+	// Looked up just before the last reference goes.
+	blob, err := cfg.blobs.GetBlob(*video.ContentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.releaseBlob(blob.Hash)
+
+	_, err = cfg.blobs.ReuseBlob(blob.Hash, blob.Key)
+	if !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("reusing a released blob returned %v, want ErrNotFound", err)
+	}
+	if _, err := cfg.blobs.GetBlob(blob.Hash); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("released blob was brought back: %v", err)
+	}
+}
+
+func TestAcquireBlobConcurrentStore(t *testing.T) {
+	cfg := newSameContentConfig(t)
+	ctx := context.Background()
+
+	video := uploadAndProcess(t, cfg, newTestVideo(t, cfg))
+	// Another upload of the same content finished storing its own copy
+	// while the first was being recorded.
+	copyKey := contentKey("landscape", *video.ContentHash)
+	if err := cfg.videoStore.Put(ctx, copyKey, strings.NewReader("normalized"), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	blob, err := cfg.acquireBlob(database.Blob{Hash: *video.ContentHash, Key: copyKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.Key != *video.VideoKey || blob.RefCount != 2 {
+		t.Errorf("acquired %s with %d references, want %s with 2", blob.Key, blob.RefCount, *video.VideoKey)
+	}
+	cfg.runCleanupTasks(ctx)
+	if _, err := cfg.videoStore.Head(ctx, copyKey); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("redundant copy wasn't deleted: %v", err)
+	}
+	if _, err := cfg.videoStore.Head(ctx, *video.VideoKey); err != nil {
+		t.Errorf("stored copy is gone: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+func isSHA256Hex(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// contentKey is where processed video bytes with the given hash are stored.
+// Streaming output and sprites live under the same name without ".mp4".
+// Each copy gets a generation of its own, so content stored again after
+// being released never lands on a key whose cleanup is still queued.
+func contentKey(prefix, hash string) string {
+	return prefix + "/" + hash + "-" + uuid.NewString()[:8] + ".mp4"
+}
+
+// applyBlob points video at the stored content of blob.
+func (cfg *apiConfig) applyBlob(video *database.Video, blob database.Blob) {
+	videoURL := cfg.videoStore.URL(blob.Key)
+	video.VideoURL = &videoURL
+	video.HLSURL = blob.HLSURL
+	video.DashURL = blob.DashURL
+	video.PreviewSprites = blob.PreviewSprites
+	hash := blob.Hash
+	video.ContentHash = &hash
+	key := blob.Key
+	video.VideoKey = &key
+}
+
+// handlerVideoPrecheck lets a client ask whether a file it already uploaded
+// is stored before sending it again. The hash may be of the file as the
+// client has it or of a processed video. On a match the video is pointed at
+// the stored content and no upload is needed.
+func (cfg *apiConfig) handlerVideoPrecheck(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		SHA256 string `json:"sha256"`
+	}
+	type response struct {
+		Duplicate bool            `json:"duplicate"`
+		Video     *database.Video `json:"video,omitempty"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	hash := strings.ToLower(params.SHA256)
+	if !isSHA256Hex(hash) {
+		respondWithError(w, http.StatusBadRequest, "sha256 must be 64 hex characters", nil)
+		return
+	}
+
+	video, err := cfg.videos.GetVideo(videoID)
+	if err != nil {
+		respondWithDBError(w, "Couldn't find video", err)
+		return
+	}
+	if userID != video.UserID {
+		respondWithError(w, http.StatusUnauthorized, "You are not authorized to upload this video", nil)
+		return
+	}
+
+	// Only the caller's own content counts, so a hash alone neither grants
+	// access to someone else's video nor tells whether it exists.
+	blob, err := cfg.blobs.GetUserBlob(userID, hash)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithJSON(w, http.StatusOK, response{Duplicate: false})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up video content", err)
+		return
+	}
+
+	blob, err = cfg.blobs.ReuseBlob(blob.Hash, blob.Key)
+	if errors.Is(err, database.ErrNotFound) {
+		// Released since the lookup; it's on its way out.
+		respondWithJSON(w, http.StatusOK, response{Duplicate: false})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reference video content", err)
+		return
+	}
+	previous := video
+	cfg.applyBlob(&video, blob)
+	err = cfg.blobs.CopyVideoMetadata(blob.Hash, videoID)
+	if err == nil {
+		var copied database.Video
+		copied, err = cfg.videos.GetVideo(videoID)
+		video.Metadata = copied.Metadata
+	}
+	if err != nil {
+		cfg.releaseBlob(blob.Hash)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save video metadata", err)
+		return
+	}
+	err = cfg.replaceVideo(previous, video)
+	if err != nil {
+		cfg.releaseBlob(blob.Hash)
+		respondWithDBError(w, "Couldn't update video", err)
+		return
+	}
+
+	video, err = cfg.videos.GetVideo(videoID)
+	if err != nil {
+		respondWithDBError(w, "Error getting video", err)
+		return
+	}
+	log.Printf("Video %v reuses stored content %v", videoID, blob.Hash)
+	respondWithJSON(w, http.StatusOK, response{Duplicate: true, Video: &video})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+func precheck(t *testing.T, cfg *apiConfig, video database.Video, hash string) (duplicate bool) {
+	t.Helper()
+	token, err := auth.MakeJWT(video.UserID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := strings.NewReader(`{"sha256": "` + hash + `"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/video_upload/"+video.ID.String()+"/precheck", body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.SetPathValue("videoID", video.ID.String())
+	w := httptest.NewRecorder()
+	cfg.handlerVideoPrecheck(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("precheck returned %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Duplicate bool `json:"duplicate"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Duplicate
+}
+
+func TestHandlerVideoPrecheckOnlyMatchesOwnContent(t *testing.T) {
+	fake := &media.Fake{
+		Metadata: loadProbe(t, "four_three.json"),
+		RunFunc: func(args []string, stdin io.Reader, stdout io.Writer) error {
+			return os.WriteFile(args[len(args)-1], []byte("normalized"), 0644)
+		},
+	}
+	cfg := newTestConfig(t, fake)
+	uploaded := newTestVideo(t, cfg)
+
+	w := httptest.NewRecorder()
+	cfg.handlerUploadVideo(w, newUploadRequest(t, cfg, uploaded, mp4Header))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("upload returned %d: %s", w.Code, w.Body)
+	}
+	if job := processNextJob(t, cfg); job.State != database.ProcessingStateReady {
+		t.Fatalf("job is %s (error %v), want ready", job.State, job.Error)
+	}
+	uploaded, err := cfg.videos.GetVideo(uploaded.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(mp4Header)
+	sourceHash := hex.EncodeToString(sum[:])
+	blobHash := *uploaded.ContentHash
+
+	other := newTestVideo(t, cfg)
+	for _, hash := range []string{sourceHash, blobHash} {
+		if precheck(t, cfg, other, hash) {
+			t.Errorf("another user's precheck of %s matched", hash)
+		}
+	}
+	other, err = cfg.videos.GetVideo(other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.VideoURL != nil {
+		t.Errorf("another user's video was pointed at %s", *other.VideoURL)
+	}
+
+	for _, hash := range []string{sourceHash, blobHash} {
+		again, err := cfg.videos.CreateVideo(database.CreateVideoParams{Title: "Again", UserID: uploaded.UserID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !precheck(t, cfg, again, hash) {
+			t.Errorf("owner's precheck of %s didn't match", hash)
+		}
+		again, err = cfg.videos.GetVideo(again.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.VideoURL == nil || *again.VideoURL != *uploaded.VideoURL {
+			t.Errorf("owner's video points at %v, want %s", again.VideoURL, *uploaded.VideoURL)
+		}
+	}
+
+	blob, err := cfg.blobs.GetBlob(blobHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.RefCount != 3 {
+		t.Errorf("blob has %d references, want 3", blob.RefCount)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// gcLocation is one place assets are stored and the keys in it that the
+// database still references. The video and thumbnail stores can be the same
+// place, e.g. both local under the assets root, so stores with the same base
+// URL share a location.
+type gcLocation struct {
+	name     string
+	store    storage.BlobStore
+	baseURL  string
+	keys     map[string]bool
+	prefixes []string
+}
+
+func (l *gcLocation) reference(key string) {
+	l.keys[key] = true
+}
+
+// referenceVideo keeps a stored video and everything packaged next to it.
+func (l *gcLocation) referenceVideo(key string) {
+	l.reference(key)
+	l.prefixes = append(l.prefixes, strings.TrimSuffix(key, ".mp4")+"/")
+}
+
+// keyFor recovers the key from a URL this location handed out. Rows written
+// before keys were recorded only have URLs.
+func (l *gcLocation) keyFor(url *string) (string, bool) {
+	if url == nil || !strings.HasPrefix(*url, l.baseURL) {
+		return "", false
+	}
+	key := strings.TrimPrefix(*url, l.baseURL)
+	return key, key != ""
+}
+
+func (l *gcLocation) referenced(key string) bool {
+	if l.keys[key] {
+		return true
+	}
+	for _, prefix := range l.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type gcOrphan struct {
+	Location string
+	storage.ObjectInfo
+}
+
+type gcReport struct {
+	Scanned        int
+	Orphans        []gcOrphan
+	OrphanBytes    int64
+	Deleted        int
+	ReclaimedBytes int64
+	Failed         int
+}
+
+func (cfg *apiConfig) gcLocations() (video, thumbnail *gcLocation) {
+	video = &gcLocation{
+		name:    "video",
+		store:   cfg.videoStore,
+		baseURL: cfg.videoStore.URL(""),
+		keys:    map[string]bool{},
+	}
+	if cfg.thumbnailStore.URL("") == video.baseURL {
+		return video, video
+	}
+	thumbnail = &gcLocation{
+		name:    "thumbnail",
+		store:   cfg.thumbnailStore,
+		baseURL: cfg.thumbnailStore.URL(""),
+		keys:    map[string]bool{},
+	}
+	return video, thumbnail
+}
+
+// collectReferences marks every key the database points at.
+func (cfg *apiConfig) collectReferences(video, thumbnail *gcLocation) error {
+	videos, err := cfg.videos.GetAllVideos()
+	if err != nil {
+		return fmt.Errorf("couldn't get videos: %w", err)
+	}
+	for _, v := range videos {
+		if v.VideoKey != nil {
+			video.referenceVideo(*v.VideoKey)
+		} else if key, ok := video.keyFor(v.VideoURL); ok {
+			video.referenceVideo(key)
+		}
+		for _, key := range v.ThumbnailKeys {
+			thumbnail.reference(key)
+		}
+		if key, ok := thumbnail.keyFor(v.ThumbnailURL); ok {
+			thumbnail.reference(key)
+		}
+		if v.Thumbnails != nil {
+			for _, variant := range v.Thumbnails.Variants {
+				if key, ok := thumbnail.keyFor(&variant.URL); ok {
+					thumbnail.reference(key)
+				}
+			}
+		}
+	}
+
+	versions, err := cfg.versions.GetAllVideoVersions()
+	if err != nil {
+		return fmt.Errorf("couldn't get video versions: %w", err)
+	}
+	for _, v := range versions {
+		if v.VideoKey != nil {
+			video.referenceVideo(*v.VideoKey)
+		} else if key, ok := video.keyFor(v.VideoURL); ok {
+			video.referenceVideo(key)
+		}
+	}
+
+	blobs, err := cfg.blobs.GetBlobs()
+	if err != nil {
+		return fmt.Errorf("couldn't get stored content: %w", err)
+	}
+	for _, blob := range blobs {
+		video.referenceVideo(blob.Key)
+	}
+	return nil
+}
+
+// collectGarbage finds objects nothing in the database references that are
+// older than grace and, unless dryRun is set, deletes them. The grace period
+// protects uploads that are still being processed and direct uploads that
+// haven't been completed yet.
+func (cfg *apiConfig) collectGarbage(ctx context.Context, grace time.Duration, dryRun bool) (gcReport, error) {
+	video, thumbnail := cfg.gcLocations()
+	locations := []*gcLocation{video}
+	if thumbnail != video {
+		locations = append(locations, thumbnail)
+	}
+
+	// List before reading references: anything stored after the listing
+	// isn't looked at, so a reference added in between can't be missed.
+	listings := make([][]storage.ObjectInfo, len(locations))
+	for i, location := range locations {
+		objects, err := location.store.List(ctx, "")
+		if err != nil {
+			return gcReport{}, fmt.Errorf("couldn't list %s storage: %w", location.name, err)
+		}
+		listings[i] = objects
+	}
+	if err := cfg.collectReferences(video, thumbnail); err != nil {
+		return gcReport{}, err
+	}
+
+	report := gcReport{}
+	cutoff := time.Now().Add(-grace)
+	for i, location := range locations {
+		for _, object := range listings[i] {
+			report.Scanned++
+			if location.referenced(object.Key) || object.LastModified.After(cutoff) {
+				continue
+			}
+			report.Orphans = append(report.Orphans, gcOrphan{Location: location.name, ObjectInfo: object})
+			report.OrphanBytes += object.Size
+			if dryRun {
+				continue
+			}
+			err := location.store.Delete(ctx, object.Key)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				log.Printf("Error deleting orphaned %s object %v: %v", location.name, object.Key, err)
+				report.Failed++
+				continue
+			}
+			report.Deleted++
+			report.ReclaimedBytes += object.Size
+		}
+	}
+	return report, nil
+}
+
+func (r gcReport) summary(dryRun bool) string {
+	if dryRun {
+		return fmt.Sprintf("scanned %d objects, %d orphaned (%s would be reclaimed)",
+			r.Scanned, len(r.Orphans), formatBytes(r.OrphanBytes))
+	}
+	summary := fmt.Sprintf("scanned %d objects, deleted %d orphans, reclaimed %s",
+		r.Scanned, r.Deleted, formatBytes(r.ReclaimedBytes))
+	if r.Failed > 0 {
+		summary += fmt.Sprintf(", %d deletes failed", r.Failed)
+	}
+	return summary
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// runGCCommand implements "tubely gc". It returns the process exit code.
+func (cfg *apiConfig) runGCCommand(args []string, defaultGrace time.Duration) int {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report orphaned objects, don't delete them")
+	grace := flags.Duration("grace", defaultGrace, "leave objects younger than this alone")
+	flags.Parse(args)
+	if *grace < 0 {
+		fmt.Fprintln(os.Stderr, "gc: -grace can't be negative")
+		return 2
+	}
+
+	report, err := cfg.collectGarbage(context.Background(), *grace, *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gc: %v\n", err)
+		return 1
+	}
+	for _, orphan := range report.Orphans {
+		fmt.Printf("%s\t%s\t%s\t%s\n", orphan.Location, orphan.Key, formatBytes(orphan.Size), orphan.LastModified.UTC().Format(time.RFC3339))
+	}
+	fmt.Println(report.summary(*dryRun))
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// scheduleGC runs the garbage collector every interval.
+func (cfg *apiConfig) scheduleGC(interval, grace time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		report, err := cfg.collectGarbage(context.Background(), grace, dryRun)
+		if err != nil {
+			log.Printf("Error collecting garbage: %v", err)
+			continue
+		}
+		log.Printf("Garbage collection: %s", report.summary(dryRun))
+	}
+}
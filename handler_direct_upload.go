@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	// S3 refuses single PUTs above 5 GiB and multipart uploads above 10,000
+	// parts, so part sizes grow with the file past minDirectPartSize.
+	maxDirectPutSize  = 5 << 30
+	minDirectPartSize = 64 << 20
+	maxDirectParts    = 10000
+)
+
+type presignedPart struct {
+	PartNumber int32  `json:"part_number"`
+	URL        string `json:"url"`
+}
+
+func (cfg *apiConfig) handlerPresignVideoUpload(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ContentType string `json:"content_type"`
+		Size        int64  `json:"size"`
+		AspectRatio string `json:"aspect_ratio"`
+		Multipart   bool   `json:"multipart"`
+	}
+	type response struct {
+		Key         string          `json:"key"`
+		Method      string          `json:"method"`
+		URL         string          `json:"url,omitempty"`
+		UploadID    string          `json:"upload_id,omitempty"`
+		PartSize    int64           `json:"part_size,omitempty"`
+		Parts       []presignedPart `json:"parts,omitempty"`
+		UploadToken string          `json:"upload_token"`
+		ExpiresAt   time.Time       `json:"expires_at"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	presigner, ok := cfg.videoStore.(storage.Presigner)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Video storage doesn't support direct uploads", nil)
+		return
+	}
+
+	video, err := cfg.videos.GetVideo(videoID)
+	if err != nil {
+		respondWithDBError(w, "Couldn't find video", err)
+		return
+	}
+	if userID != video.UserID {
+		respondWithError(w, http.StatusUnauthorized, "You are not authorized to upload this video", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.ContentType != "video/mp4" {
+		respondWithError(w, http.StatusBadRequest, "Invalid MediaType", nil)
+		return
+	}
+	if params.Size <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Size is required", nil)
+		return
+	}
+	if params.AspectRatio == "" {
+		params.AspectRatio = "other"
+	}
+	prefix, err := aspectRatioPrefix(params.AspectRatio)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Aspect Ratio", err)
+		return
+	}
+	key, err := newVideoKey(prefix)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate video key", err)
+		return
+	}
+
+	resp := response{
+		Key:       key,
+		Method:    http.MethodPut,
+		ExpiresAt: time.Now().UTC().Add(cfg.directUploadTTL),
+	}
+	if params.Multipart || params.Size > maxDirectPutSize {
+		resp.PartSize = max(minDirectPartSize, (params.Size+maxDirectParts-1)/maxDirectParts)
+		partCount := int32((params.Size + resp.PartSize - 1) / resp.PartSize)
+		resp.UploadID, err = presigner.CreateMultipartUpload(r.Context(), key, params.ContentType)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't start multipart upload", err)
+			return
+		}
+		for partNumber := int32(1); partNumber <= partCount; partNumber++ {
+			url, err := presigner.PresignUploadPart(r.Context(), key, resp.UploadID, partNumber, cfg.directUploadTTL)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload part", err)
+				return
+			}
+			resp.Parts = append(resp.Parts, presignedPart{PartNumber: partNumber, URL: url})
+		}
+	} else {
+		resp.URL, err = presigner.PresignPut(r.Context(), key, params.ContentType, cfg.directUploadTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload", err)
+			return
+		}
+	}
+
+	upload, err := cfg.directUploads.CreateDirectUpload(videoID, key)
+	if err != nil {
+		respondWithDBError(w, "Couldn't record upload", err)
+		return
+	}
+	resp.UploadToken, err = auth.MakeDirectUploadToken(upload.ID, userID, videoID, key, resp.UploadID, cfg.jwtSecret, cfg.directUploadTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload token", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerCompleteVideoUpload(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		UploadToken string                  `json:"upload_token"`
+		Parts       []storage.CompletedPart `json:"parts"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	presigner, ok := cfg.videoStore.(storage.Presigner)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Video storage doesn't support direct uploads", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	claims, err := auth.ValidateDirectUploadToken(params.UploadToken, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate upload token", err)
+		return
+	}
+	if claims.Subject != userID.String() || claims.VideoID != videoID.String() {
+		respondWithError(w, http.StatusUnauthorized, "Upload token doesn't match this video", nil)
+		return
+	}
+	uploadID, err := uuid.Parse(claims.ID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate upload token", err)
+		return
+	}
+	upload, err := cfg.directUploads.GetDirectUpload(uploadID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusUnauthorized, "Unknown upload token", nil)
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, "Couldn't get upload", err)
+		return
+	}
+	if upload.CompletedAt != nil {
+		respondWithError(w, http.StatusConflict, "Upload was already completed", nil)
+		return
+	}
+
+	video, err := cfg.videos.GetVideo(videoID)
+	if err != nil {
+		respondWithDBError(w, "Couldn't find video", err)
+		return
+	}
+	if userID != video.UserID {
+		respondWithError(w, http.StatusUnauthorized, "You are not authorized to upload this video", nil)
+		return
+	}
+
+	if claims.UploadID != "" {
+		if len(params.Parts) == 0 {
+			respondWithError(w, http.StatusBadRequest, "Parts are required for multipart uploads", nil)
+			return
+		}
+		err = presigner.CompleteMultipartUpload(r.Context(), claims.Key, claims.UploadID, params.Parts)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't complete multipart upload", err)
+			return
+		}
+	}
+
+	_, err = cfg.videoStore.Head(r.Context(), claims.Key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusBadRequest, "Uploaded video not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check uploaded video", err)
+		return
+	}
+
+	// The upload goes through the same pipeline as any other, so it is
+	// processed from a local copy in the spool.
+	sourcePath, err := cfg.spoolDirectUpload(r.Context(), claims.Key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read uploaded video", err)
+		return
+	}
+	verr, err := cfg.validateVideoFile(r.Context(), sourcePath)
+	if err != nil {
+		os.Remove(sourcePath)
+		respondWithError(w, http.StatusInternalServerError, "Error validating video", err)
+		return
+	}
+	if verr != nil {
+		os.Remove(sourcePath)
+		cfg.rejectDirectUpload(w, r, claims.Key, verr)
+		return
+	}
+	// Marking the upload completed and queueing it happen together, so of
+	// two concurrent completions only one queues a job.
+	job, err := cfg.directUploads.CompleteDirectUpload(upload.ID, sourcePath)
+	if errors.Is(err, database.ErrConflict) {
+		os.Remove(sourcePath)
+		respondWithError(w, http.StatusConflict, "Upload was already completed", nil)
+		return
+	}
+	if err != nil {
+		os.Remove(sourcePath)
+		respondWithError(w, http.StatusInternalServerError, "Error queueing video for processing", err)
+		return
+	}
+	cfg.wakeProcessing()
+	// Processing stores its own copy, so the uploaded object has served
+	// its purpose.
+	cfg.scheduleCleanup(database.CleanupTask{Store: database.CleanupStoreVideo, Key: claims.Key})
+
+	log.Printf("Completed direct upload of video %v with key %v", videoID, claims.Key)
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// spoolDirectUpload downloads the object at key into the processing spool.
+func (cfg *apiConfig) spoolDirectUpload(ctx context.Context, key string) (string, error) {
+	body, _, err := cfg.videoStore.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	spoolFile, err := os.CreateTemp(cfg.processingSpoolDir, "upload-*.mp4")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(spoolFile, body)
+	if err == nil {
+		err = spoolFile.Close()
+	} else {
+		spoolFile.Close()
+	}
+	if err != nil {
+		os.Remove(spoolFile.Name())
+		return "", err
+	}
+	return spoolFile.Name(), nil
+}
+
+// rejectDirectUpload deletes an upload that failed validation; it would
+// otherwise sit in the bucket with nothing pointing at it.
+func (cfg *apiConfig) rejectDirectUpload(w http.ResponseWriter, r *http.Request, key string, verr *validationError) {
+	if err := cfg.videoStore.Delete(r.Context(), key); err != nil {
+		log.Printf("Error deleting rejected upload %v: %v", key, err)
+	}
+	respondWithValidationError(w, verr)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// presignStore hands out placeholder URLs; tests put the "uploaded" object
+// into the store themselves.
+type presignStore struct {
+	*storage.MemoryStore
+}
+
+func (s presignStore) PresignPut(ctx context.Context, key, contentType string, ttl time.Duration) (string, error) {
+	return s.URL(key), nil
+}
+
+func (s presignStore) PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return s.URL(key), nil
+}
+
+func (s presignStore) CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	return "", errors.New("multipart uploads aren't supported")
+}
+
+func (s presignStore) PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, ttl time.Duration) (string, error) {
+	return "", errors.New("multipart uploads aren't supported")
+}
+
+func (s presignStore) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []storage.CompletedPart) error {
+	return errors.New("multipart uploads aren't supported")
+}
+
+func (s presignStore) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	return errors.New("multipart uploads aren't supported")
+}
+
+func presignDirectUpload(t *testing.T, cfg *apiConfig, video database.Video) (key, uploadToken string) {
+	t.Helper()
+	token, err := auth.MakeJWT(video.UserID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"content_type": "video/mp4", "size": 1024}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.SetPathValue("videoID", video.ID.String())
+	w := httptest.NewRecorder()
+	cfg.handlerPresignVideoUpload(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("presign returned %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Key         string `json:"key"`
+		UploadToken string `json:"upload_token"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Key, resp.UploadToken
+}
+
+func completeDirectUpload(t *testing.T, cfg *apiConfig, video database.Video, uploadToken string) *httptest.ResponseRecorder {
+	t.Helper()
+	token, err := auth.MakeJWT(video.UserID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]string{"upload_token": uploadToken})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.SetPathValue("videoID", video.ID.String())
+	w := httptest.NewRecorder()
+	cfg.handlerCompleteVideoUpload(w, req)
+	return w
+}
+
+func TestHandlerCompleteVideoUpload(t *testing.T) {
+	fake := &media.Fake{
+		Metadata: loadProbe(t, "anamorphic.json"),
+		RunFunc: func(args []string, stdin io.Reader, stdout io.Writer) error {
+			return os.WriteFile(args[len(args)-1], []byte("transcoded"), 0644)
+		},
+	}
+	cfg := newTestConfig(t, fake)
+	cfg.videoStore = presignStore{storage.NewMemoryStore("https://cdn.example.com")}
+	cfg.directUploadTTL = time.Hour
+	video := newTestVideo(t, cfg)
+
+	key, uploadToken := presignDirectUpload(t, cfg, video)
+	if err := cfg.videoStore.Put(t.Context(), key, bytes.NewReader(mp4Header), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	w := completeDirectUpload(t, cfg, video, uploadToken)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("complete returned %d: %s", w.Code, w.Body)
+	}
+
+	// The upload is processed like any other, from a spooled copy.
+	job := processNextJob(t, cfg)
+	if job.State != database.ProcessingStateReady {
+		t.Fatalf("job is %s (error %v), want ready", job.State, job.Error)
+	}
+	video, err := cfg.videos.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.ContentHash == nil || video.VideoKey == nil || *video.VideoKey == key {
+		t.Errorf("video %+v wasn't stored by the pipeline", video)
+	}
+	tasks, err := cfg.cleanupTasks.GetDueCleanupTasks(time.Now(), cleanupBatchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].Key != key {
+		t.Errorf("cleanup tasks %+v, want the uploaded object %v", tasks, key)
+	}
+}
+
+func TestHandlerCompleteVideoUploadOnlyOnce(t *testing.T) {
+	cfg := newTestConfig(t, &media.Fake{Metadata: loadProbe(t, "anamorphic.json")})
+	cfg.videoStore = presignStore{storage.NewMemoryStore("https://cdn.example.com")}
+	cfg.directUploadTTL = time.Hour
+	video := newTestVideo(t, cfg)
+
+	key, uploadToken := presignDirectUpload(t, cfg, video)
+	if err := cfg.videoStore.Put(t.Context(), key, bytes.NewReader(mp4Header), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if w := completeDirectUpload(t, cfg, video, uploadToken); w.Code != http.StatusAccepted {
+		t.Fatalf("complete returned %d: %s", w.Code, w.Body)
+	}
+	first, err := cfg.processingJobs.GetLatestProcessingJob(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The object is still there until cleanup runs, but the token is spent.
+	if w := completeDirectUpload(t, cfg, video, uploadToken); w.Code != http.StatusConflict {
+		t.Errorf("replayed completion returned %d, want 409", w.Code)
+	}
+	latest, err := cfg.processingJobs.GetLatestProcessingJob(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.ID != first.ID {
+		t.Errorf("replayed completion queued job %v", latest.ID)
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
@@ -18,11 +20,15 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
-	if err != nil {
+	user, err := cfg.users.GetUserByRefreshToken(refreshToken)
+	if errors.Is(err, database.ErrNotFound) {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
 		return
 	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user for refresh token", err)
+		return
+	}
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
@@ -46,7 +52,11 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.db.RevokeRefreshToken(refreshToken)
+	err = cfg.refreshTokens.RevokeRefreshToken(refreshToken)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find session", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
 		return
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// maxThumbnailTimestamp is the largest timestamp, in seconds, that fits in a
+// time.Duration.
+const maxThumbnailTimestamp = float64(math.MaxInt64 / int64(time.Second))
+
+// handlerRegenerateThumbnail replaces the video's thumbnail with the frame at
+// the requested timestamp, given in seconds.
+func (cfg *apiConfig) handlerRegenerateThumbnail(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Timestamp float64 `json:"timestamp"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	// Anything past maxThumbnailTimestamp would overflow a time.Duration.
+	if math.IsNaN(params.Timestamp) || params.Timestamp < 0 || params.Timestamp > maxThumbnailTimestamp {
+		respondWithError(w, http.StatusBadRequest, "Timestamp must be a number of seconds into the video", nil)
+		return
+	}
+
+	video, err := cfg.videos.GetVideo(videoID)
+	if err != nil {
+		respondWithDBError(w, "Couldn't find video", err)
+		return
+	}
+	if userID != video.UserID {
+		respondWithError(w, http.StatusUnauthorized, "You are not authorized to update this video", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Video hasn't been uploaded yet", nil)
+		return
+	}
+	if video.Metadata != nil && video.Metadata.Duration > 0 && params.Timestamp > video.Metadata.Duration {
+		respondWithError(w, http.StatusBadRequest, "Timestamp is past the end of the video", nil)
+		return
+	}
+
+	input, err := cfg.videoSourceURL(r.Context(), *video.VideoURL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video source", err)
+		return
+	}
+	at := time.Duration(params.Timestamp * float64(time.Second))
+	stored, err := cfg.generateThumbnail(r.Context(), input, at)
+	if errors.Is(err, errNoFrameAt) {
+		respondWithError(w, http.StatusBadRequest, "Timestamp is past the end of the video", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate thumbnail", err)
+		return
+	}
+
+	previousKeys := video.ThumbnailKeys
+	stored.applyTo(&video, true)
+	err = cfg.videos.UpdateVideo(video)
+	if err != nil {
+		cfg.deleteThumbnails(stored.Keys)
+		respondWithDBError(w, "Couldn't update video", err)
+		return
+	}
+	cfg.retireThumbnails(previousKeys, video.ThumbnailKeys)
+	respondWithJSON(w, http.StatusOK, video)
+}
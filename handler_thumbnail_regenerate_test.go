@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+func TestHandlerRegenerateThumbnailRejectsTimestamps(t *testing.T) {
+	fake := &media.Fake{
+		Metadata: loadProbe(t, "anamorphic.json"),
+		RunFunc: func(args []string, stdin io.Reader, stdout io.Writer) error {
+			return os.WriteFile(args[len(args)-1], []byte("transcoded"), 0644)
+		},
+	}
+	cfg := newTestConfig(t, fake)
+	video := newTestVideo(t, cfg)
+	w := httptest.NewRecorder()
+	cfg.handlerUploadVideo(w, newUploadRequest(t, cfg, video, mp4Header))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("upload returned %d: %s", w.Code, w.Body)
+	}
+	if job := processNextJob(t, cfg); job.State != database.ProcessingStateReady {
+		t.Fatalf("job is %s (error %v), want ready", job.State, job.Error)
+	}
+	token, err := auth.MakeJWT(video.UserID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := len(fake.Runs())
+
+	// The probed video is 5 seconds long.
+	for _, body := range []string{`{"timestamp": -1}`, `{"timestamp": 5.5}`, `{"timestamp": 1e300}`} {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.SetPathValue("videoID", video.ID.String())
+		w := httptest.NewRecorder()
+		cfg.handlerRegenerateThumbnail(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s returned %d, want 400", body, w.Code)
+		}
+	}
+	if len(fake.Runs()) != runs {
+		t.Errorf("ran ffmpeg for a rejected timestamp")
+	}
+}
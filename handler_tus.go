@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tus"
+	"github.com/google/uuid"
+)
+
+const tusMaxSize = 10 << 30
+
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tus.Version)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func setTusExpires(w http.ResponseWriter, upload tus.Upload) {
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+func (cfg *apiConfig) handlerTusOptions(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	w.Header().Set("Tus-Version", tus.Version)
+	w.Header().Set("Tus-Extension", tus.Extensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(tusMaxSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusCreate(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	if r.Header.Get("Tus-Resumable") != tus.Version {
+		w.Header().Set("Tus-Version", tus.Version)
+		respondWithError(w, http.StatusPreconditionFailed, "Unsupported tus version", nil)
+		return
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.videos.GetVideo(videoID)
+	if err != nil {
+		respondWithDBError(w, "Couldn't find video", err)
+		return
+	}
+	if userID != video.UserID {
+		respondWithError(w, http.StatusUnauthorized, "You are not authorized to upload this video", nil)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Length", err)
+		return
+	}
+	if length > tusMaxSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload exceeds Tus-Max-Size", nil)
+		return
+	}
+
+	metadata, err := tus.ParseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Metadata", err)
+		return
+	}
+	if fileType, ok := metadata["filetype"]; ok && !strings.HasPrefix(fileType, "video/") {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Invalid MediaType", nil)
+		return
+	}
+
+	upload, err := cfg.tusStore.Create(videoID, userID, length, metadata, time.Now().UTC().Add(cfg.tusExpiry))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+
+	setTusExpires(w, upload)
+	w.Header().Set("Location", fmt.Sprintf("/api/tus/%s/%s", videoID, upload.ID))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// tusUpload resolves the upload addressed by the request path and checks that
+// it belongs to the authenticated user. It writes the error response itself
+// and returns false when the request should not continue.
+func (cfg *apiConfig) tusUpload(w http.ResponseWriter, r *http.Request) (tus.Upload, bool) {
+	setTusHeaders(w)
+	if r.Header.Get("Tus-Resumable") != tus.Version {
+		w.Header().Set("Tus-Version", tus.Version)
+		respondWithError(w, http.StatusPreconditionFailed, "Unsupported tus version", nil)
+		return tus.Upload{}, false
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return tus.Upload{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return tus.Upload{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return tus.Upload{}, false
+	}
+
+	upload, err := cfg.tusStore.Get(r.PathValue("uploadID"))
+	if errors.Is(err, tus.ErrNotFound) || (err == nil && upload.VideoID != videoID) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find upload", err)
+		return tus.Upload{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return tus.Upload{}, false
+	}
+	if upload.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "You are not authorized to upload this video", nil)
+		return tus.Upload{}, false
+	}
+	if upload.ExpiresAt.Before(time.Now()) {
+		respondWithError(w, http.StatusGone, "Upload has expired", nil)
+		return tus.Upload{}, false
+	}
+	return upload, true
+}
+
+func (cfg *apiConfig) handlerTusHead(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.tusUpload(w, r)
+	if !ok {
+		return
+	}
+	setTusExpires(w, upload)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) handlerTusPatch(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.tusUpload(w, r)
+	if !ok {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream", nil)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Offset", err)
+		return
+	}
+	if r.ContentLength > 0 && offset+r.ContentLength > upload.Length {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Chunk exceeds Upload-Length", nil)
+		return
+	}
+
+	upload, err = cfg.tusStore.WriteChunk(upload.ID, offset, r.Body, time.Now().UTC().Add(cfg.tusExpiry))
+	switch {
+	case errors.Is(err, tus.ErrOffsetMismatch):
+		respondWithError(w, http.StatusConflict, "Upload-Offset does not match", err)
+		return
+	case errors.Is(err, tus.ErrLocked):
+		respondWithError(w, http.StatusLocked, "Upload is busy", err)
+		return
+	case errors.Is(err, tus.ErrTooLarge):
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Chunk exceeds Upload-Length", err)
+		return
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, "Couldn't write chunk", err)
+		return
+	}
+
+	if upload.Complete() {
+		if !cfg.finishTusUpload(w, r, upload) {
+			return
+		}
+	}
+
+	setTusExpires(w, upload)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishTusUpload validates a complete upload and queues it for processing.
+// It runs under the upload's lock, so a PATCH racing to complete the same
+// upload gets 423 or 404 instead of queueing it twice. It writes the error
+// response itself and returns false when the request should not continue.
+func (cfg *apiConfig) finishTusUpload(w http.ResponseWriter, r *http.Request, upload tus.Upload) bool {
+	var verr *validationError
+	queued := false
+	err := cfg.tusStore.Finish(upload.ID, func(upload tus.Upload) error {
+		var err error
+		verr, err = cfg.validateVideoFile(r.Context(), cfg.tusStore.DataPath(upload.ID))
+		if err != nil {
+			return fmt.Errorf("validating video: %w", err)
+		}
+		if verr != nil {
+			// Nothing the client can resume into a valid video.
+			return nil
+		}
+		sourcePath, err := cfg.spoolUpload(cfg.tusStore.DataPath(upload.ID))
+		if err != nil {
+			return fmt.Errorf("saving upload for processing: %w", err)
+		}
+		if _, err := cfg.enqueueProcessing(upload.VideoID, sourcePath); err != nil {
+			os.Remove(sourcePath)
+			return fmt.Errorf("queueing video for processing: %w", err)
+		}
+		queued = true
+		return nil
+	})
+	switch {
+	case err != nil && (queued || verr != nil):
+		log.Printf("Error cleaning up upload %v: %v", upload.ID, err)
+	case errors.Is(err, tus.ErrLocked):
+		respondWithError(w, http.StatusLocked, "Upload is busy", err)
+		return false
+	case errors.Is(err, tus.ErrNotFound):
+		respondWithError(w, http.StatusNotFound, "Couldn't find upload", err)
+		return false
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, "Couldn't finish upload", err)
+		return false
+	}
+	if verr != nil {
+		respondWithValidationError(w, verr)
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) handlerTusDelete(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.tusUpload(w, r)
+	if !ok {
+		return
+	}
+	err := cfg.tusStore.Terminate(upload.ID)
+	if errors.Is(err, tus.ErrLocked) {
+		respondWithError(w, http.StatusLocked, "Upload is busy", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't terminate upload", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sweepExpiredTusUploads periodically removes partial uploads whose
+// Upload-Expires has passed.
+func (cfg *apiConfig) sweepExpiredTusUploads(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		removed, err := cfg.tusStore.RemoveExpired(time.Now().UTC())
+		if err != nil {
+			log.Printf("Error removing expired uploads: %v", err)
+			continue
+		}
+		if removed > 0 {
+			log.Printf("Removed %d expired uploads", removed)
+		}
+	}
+}
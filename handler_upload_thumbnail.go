@@ -1,22 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/thumbnails"
 	"github.com/google/uuid"
+	"image"
 	"io"
 	"log"
-	"mime"
 	"mime/multipart"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
 )
 
+const maxThumbnailBytes = 10 << 20
+
 func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -39,15 +42,20 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 
 	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
 
-	// TODO: implement the upload here
-	const maxMemory = 10 << 20
-	err = r.ParseMultipartForm(maxMemory)
+	// Leave room for the multipart framing around the file itself.
+	r.Body = http.MaxBytesReader(w, r.Body, maxThumbnailBytes+1<<20)
+	err = r.ParseMultipartForm(maxThumbnailBytes)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Too large file", err)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Thumbnail can't be larger than 10 MiB", err)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Invalid multipart form", err)
 		return
 	}
 
-	file, header, err := r.FormFile("thumbnail")
+	file, _, err := r.FormFile("thumbnail")
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid upload file", err)
 		return
@@ -58,74 +66,127 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 			log.Printf("Error closing file: %v\n", err)
 		}
 	}(file)
-	mType := header.Header.Get("Content-Type")
-	mediaType, _, err := mime.ParseMediaType(mType)
+	data, err := io.ReadAll(io.LimitReader(file, maxThumbnailBytes+1))
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't read thumbnail", err)
+		return
+	}
+	if len(data) > maxThumbnailBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Thumbnail can't be larger than 10 MiB", nil)
 		return
 	}
-	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type", err)
+
+	// The client's Content-Type is ignored; Decode sniffs the real type.
+	img, _, err := thumbnails.Decode(data)
+	switch {
+	case errors.Is(err, thumbnails.ErrUnsupportedType):
+		respondWithError(w, http.StatusUnsupportedMediaType, "Thumbnail must be a JPEG, PNG or WebP image", err)
+		return
+	case errors.Is(err, thumbnails.ErrTooLarge):
+		respondWithError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Thumbnail can't be larger than %dx%d", thumbnails.MaxDimension, thumbnails.MaxDimension), err)
+		return
+	case err != nil:
+		respondWithError(w, http.StatusBadRequest, "Thumbnail image is corrupt", err)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.videos.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't find video", err)
+		respondWithDBError(w, "Couldn't find video", err)
 		return
 	}
 	if userID != video.UserID {
 		respondWithError(w, http.StatusUnauthorized, "You are not authorized to upload this video", err)
 		return
 	}
-	mediaSubtype, ok := strings.CutPrefix(mediaType, "image/")
-	if !ok {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type", err)
-		return
-	}
-	randomBuf := make([]byte, 32)
-	_, err = rand.Read(randomBuf)
+
+	stored, err := cfg.storeThumbnailImage(r.Context(), img)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't generate random string", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store thumbnail", err)
 		return
 	}
-	randomVideoId := base64.RawURLEncoding.EncodeToString(randomBuf)
-	newVideoPath := filepath.Join(cfg.assetsRoot, fmt.Sprintf("%s.%v", randomVideoId, mediaSubtype))
-	newVideoFile, err := os.Create(newVideoPath)
+
+	previousKeys := video.ThumbnailKeys
+	stored.applyTo(&video, false)
+	err = cfg.videos.UpdateVideo(video)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create file", err)
+		cfg.deleteThumbnails(stored.Keys)
+		respondWithDBError(w, "Couldn't update video", err)
 		return
 	}
-	defer newVideoFile.Close()
-	_, err = io.Copy(newVideoFile, file)
+	cfg.retireThumbnails(previousKeys, video.ThumbnailKeys)
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// storedThumbnail is one thumbnail rendered in every size and format.
+type storedThumbnail struct {
+	// URL is the largest variant in the configured primary format and
+	// becomes the video's thumbnail_url.
+	URL  string
+	Set  database.ThumbnailSet
+	Keys []string
+}
+
+func (t storedThumbnail) applyTo(video *database.Video, generated bool) {
+	video.ThumbnailURL = &t.URL
+	video.ThumbnailGenerated = generated
+	set := t.Set
+	video.Thumbnails = &set
+	video.ThumbnailKeys = t.Keys
+}
+
+// storeThumbnailImage renders every size and format of img and uploads them
+// under one random prefix, e.g. "<random>/640x360.webp".
+func (cfg *apiConfig) storeThumbnailImage(ctx context.Context, img image.Image) (storedThumbnail, error) {
+	prefix, err := newThumbnailPrefix()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't copy file", err)
-		return
+		return storedThumbnail{}, err
 	}
-	dataURL := fmt.Sprintf("http://localhost:%v/assets/%v.%v", cfg.port, randomVideoId, mediaSubtype)
-
-	err = cfg.db.UpdateVideo(database.Video{
-		ID:           videoID,
-		VideoURL:     video.VideoURL,
-		ThumbnailURL: &dataURL,
-		CreateVideoParams: database.CreateVideoParams{
-			Title:       video.Title,
-			Description: video.Description,
-			UserID:      userID,
-		},
-	})
+	stored := storedThumbnail{}
+	for _, size := range thumbnails.SizesFor(img.Bounds()) {
+		resized := thumbnails.Resize(img, size)
+		for _, format := range thumbnails.Formats {
+			key := prefix + "/" + size.String() + format.Ext
+			err := cfg.putThumbnailVariant(ctx, key, resized, format)
+			if err != nil {
+				cfg.deleteThumbnails(stored.Keys)
+				return storedThumbnail{}, fmt.Errorf("couldn't store %v %s thumbnail: %w", size, format.Name, err)
+			}
+			stored.Keys = append(stored.Keys, key)
+			url := cfg.thumbnailStore.URL(key)
+			stored.Set.Variants = append(stored.Set.Variants, database.ThumbnailVariant{
+				Width:  size.Width,
+				Height: size.Height,
+				Format: format.Name,
+				URL:    url,
+			})
+			if stored.URL == "" && format == cfg.thumbnailFormat {
+				stored.URL = url
+			}
+		}
+	}
+	return stored, nil
+}
+
+func (cfg *apiConfig) putThumbnailVariant(ctx context.Context, key string, img image.Image, format thumbnails.Format) error {
+	var buf bytes.Buffer
+	if err := thumbnails.Encode(ctx, cfg.transcoder, &buf, img, format); err != nil {
+		return err
+	}
+	return cfg.thumbnailStore.Put(ctx, key, &buf, format.MediaType)
+}
+
+// deleteThumbnails queues thumbnail objects that nothing points at for
+// deletion.
+func (cfg *apiConfig) deleteThumbnails(keys []string) {
+	cfg.scheduleCleanup(thumbnailCleanup(keys)...)
+}
+
+func newThumbnailPrefix() (string, error) {
+	randomBuf := make([]byte, 32)
+	_, err := rand.Read(randomBuf)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
-		return
+		return "", err
 	}
-	respondWithJSON(w, http.StatusOK, database.Video{
-		ID:           videoID,
-		VideoURL:     video.VideoURL,
-		ThumbnailURL: &dataURL,
-		CreateVideoParams: database.CreateVideoParams{
-			Title:       video.Title,
-			Description: video.Description,
-			UserID:      userID,
-		},
-	})
+	return base64.RawURLEncoding.EncodeToString(randomBuf), nil
 }
@@ -1,67 +1,203 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
 	"github.com/google/uuid"
 	"io"
 	"log"
-	"math/big"
-	"mime"
 	"mime/multipart"
 	"net/http"
 	"os"
-	"os/exec"
-	"strconv"
+	"strings"
 )
 
-func processVideoForFastStart(filePath string) (string, error) {
-	// cmd he command is ffmpeg and the arguments are -i,
-	// the input file path, -c, copy, -movflags, faststart, -f, mp4 and the output file path.
-	args := []string{"-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", filePath + ".processing"}
-	cmd := exec.Command("ffmpeg", args...)
-	err := cmd.Run()
+// videoDimensions returns the display size of the primary video stream and
+// whether the file carries any audio.
+func videoDimensions(videoMetaData media.Metadata) (width, height int, hasAudio bool) {
+	if index, ok := primaryVideoStream(videoMetaData); ok {
+		width, height = displaySize(videoMetaData, index)
+	}
+	for _, stream := range videoMetaData.Streams {
+		if stream.CodecType == "audio" {
+			hasAudio = true
+		}
+	}
+	return width, height, hasAudio
+}
+
+func newVideoKey(prefix string) (string, error) {
+	randomBuf := make([]byte, 32)
+	_, err := rand.Read(randomBuf)
 	if err != nil {
 		return "", err
 	}
-	return filePath + ".processing", nil
+	return prefix + "/" + fmt.Sprintf("%v.mp4", hex.EncodeToString(randomBuf)), nil
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
-	// cmd ffprobe -v error -print_format json -show_streams samples/boots-video-horizontal.mp4
-	args := []string{"-v", "error", "-print_format", "json", "-show_streams", filePath}
-	getVideoMetaDataCmd := exec.Command("ffprobe", args...)
-	var bytesBuffer bytes.Buffer
-	getVideoMetaDataCmd.Stdout = bufio.NewWriter(&bytesBuffer)
-	err := getVideoMetaDataCmd.Run()
+// publishVideo runs the processing pipeline on the upload at srcPath, stores
+// the result and points the video record at it. Every upload path ends here.
+// progress is called as the pipeline moves between stages.
+//
+// Processed files are stored once per content hash. When another video
+// already produced the same bytes its objects are reused and only the
+// reference count goes up.
+func (cfg *apiConfig) publishVideo(ctx context.Context, videoID uuid.UUID, srcPath string, progress func(database.ProcessingState)) (database.Video, error) {
+	progress(database.ProcessingStateProbing)
+	videoMetaData, err := cfg.prober.Probe(ctx, srcPath)
 	if err != nil {
-		return "", err
+		return database.Video{}, fmt.Errorf("couldn't probe video: %w", err)
 	}
-	var videoMetaData VideoMetaData
-	err = json.Unmarshal(bytesBuffer.Bytes(), &videoMetaData)
+	ratio, err := detectAspectRatio(videoMetaData)
 	if err != nil {
-		return "", err
+		return database.Video{}, fmt.Errorf("couldn't get video aspect ratio: %w", err)
+	}
+
+	progress(database.ProcessingStateTranscoding)
+	processedPath, plan, err := cfg.normalizeVideo(ctx, srcPath, videoMetaData)
+	if err != nil {
+		return database.Video{}, fmt.Errorf("couldn't process video: %w", err)
+	}
+	defer os.Remove(processedPath)
+	// What gets stored is the re-encoded file, so describe that one.
+	storedMetaData := videoMetaData
+	if plan.Transcoded() {
+		storedMetaData, err = cfg.prober.Probe(ctx, processedPath)
+		if err != nil {
+			return database.Video{}, fmt.Errorf("couldn't probe processed video: %w", err)
+		}
+	}
+	contentHash, size, err := fileSHA256(processedPath)
+	if err != nil {
+		return database.Video{}, fmt.Errorf("couldn't hash processed video: %w", err)
+	}
+	sourceHash, _, err := fileSHA256(srcPath)
+	if err != nil {
+		return database.Video{}, fmt.Errorf("couldn't hash uploaded video: %w", err)
+	}
+	generatedThumbnail := cfg.autoThumbnail(ctx, videoID, srcPath)
+
+	blob, err := cfg.blobs.GetBlob(contentHash)
+	if err == nil {
+		blob, err = cfg.blobs.ReuseBlob(blob.Hash, blob.Key)
+		if err == nil {
+			log.Printf("Video %v has the same content as %v, reusing it", videoID, blob.Key)
+		}
+	}
+	if errors.Is(err, database.ErrNotFound) {
+		blob, err = cfg.storeBlob(ctx, videoID, srcPath, processedPath, videoMetaData, ratio, contentHash, size, progress)
+		if err != nil {
+			return database.Video{}, err
+		}
+		blob, err = cfg.acquireBlob(blob)
+	}
+	if err != nil {
+		return database.Video{}, fmt.Errorf("couldn't reference video content: %w", err)
+	}
+	err = cfg.blobs.AddBlobSource(sourceHash, blob.Hash)
+	if err != nil {
+		log.Printf("Error recording source hash of video %v: %v", videoID, err)
+	}
+
+	// Re-read the record: processing can take long enough for the owner to
+	// have changed the title or thumbnail in the meantime.
+	video, err := cfg.videos.GetVideo(videoID)
+	if errors.Is(err, database.ErrNotFound) {
+		err = errors.New("video was deleted during processing")
+	}
+	if err != nil {
+		cfg.releaseBlob(blob.Hash)
+		cfg.deleteThumbnails(generatedThumbnail.Keys)
+		return database.Video{}, fmt.Errorf("couldn't get video: %w", err)
+	}
+	previous := video
+	cfg.applyBlob(&video, blob)
+	if len(generatedThumbnail.Keys) > 0 {
+		if video.ThumbnailURL == nil || video.ThumbnailGenerated {
+			generatedThumbnail.applyTo(&video, true)
+		} else {
+			// The owner uploaded a thumbnail while we were processing.
+			cfg.deleteThumbnails(generatedThumbnail.Keys)
+			generatedThumbnail.Keys = nil
+		}
+	}
+	metadata := technicalMetadata(storedMetaData)
+	video.Metadata = &metadata
+	err = cfg.replaceVideo(previous, video)
+	if err != nil {
+		cfg.releaseBlob(blob.Hash)
+		cfg.deleteThumbnails(generatedThumbnail.Keys)
+		return database.Video{}, fmt.Errorf("couldn't update video: %w", err)
+	}
+	cfg.retireThumbnails(previous.ThumbnailKeys, video.ThumbnailKeys)
+	log.Printf("Successfully uploaded video: %v, to storage with key: %v", video.ID, blob.Key)
+	return cfg.videos.GetVideo(video.ID)
+}
+
+// storeBlob packages and uploads content that isn't stored yet under a new
+// content-addressed key and returns the blob describing it.
+func (cfg *apiConfig) storeBlob(ctx context.Context, videoID uuid.UUID, srcPath, processedPath string, videoMetaData media.Metadata, ratio aspectRatio, contentHash string, size int64, progress func(database.ProcessingState)) (database.Blob, error) {
+	key := contentKey(ratio.Prefix(), contentHash)
+	stream, err := cfg.buildStreaming(ctx, srcPath, videoMetaData)
+	if err != nil {
+		return database.Blob{}, fmt.Errorf("couldn't package streaming output: %w", err)
+	}
+	if stream.Dir != "" {
+		defer os.RemoveAll(stream.Dir)
+	}
+	sprites, err := cfg.buildSprites(ctx, srcPath, videoMetaData)
+	if err != nil {
+		// Seek previews are a nicety; the video is still playable without.
+		log.Printf("Error generating preview sprites for video %v: %v", videoID, err)
+	}
+	if sprites.Dir != "" {
+		defer os.RemoveAll(sprites.Dir)
 	}
-	aspectRatio := float64(videoMetaData.Streams[0].Width) / float64(videoMetaData.Streams[0].Height)
 
-	if aspectRatio > (16.0/9.0)-0.1 && aspectRatio < (16.0/9.0)+0.1 {
-		return "16:9", nil
-	} else if aspectRatio > (9.0/16.0)-0.1 && aspectRatio < (9.0/16.0)+0.1 {
-		return "9:16", nil
+	progress(database.ProcessingStateUploading)
+	processedFile, err := os.Open(processedPath)
+	if err != nil {
+		return database.Blob{}, fmt.Errorf("couldn't open processed video: %w", err)
 	}
-	return "other", nil
+	defer processedFile.Close()
+	err = cfg.videoStore.Put(ctx, key, processedFile, "video/mp4")
+	if err != nil {
+		return database.Blob{}, fmt.Errorf("couldn't upload video: %w", err)
+	}
+	blob := database.Blob{Hash: contentHash, Key: key, Size: size}
+	if stream.Dir != "" {
+		streamPrefix := strings.TrimSuffix(key, ".mp4") + "/stream"
+		err = putDir(ctx, cfg.videoStore, stream.Dir, streamPrefix)
+		if err != nil {
+			return database.Blob{}, fmt.Errorf("couldn't upload streaming output: %w", err)
+		}
+		if stream.HLSPath != "" {
+			hlsURL := cfg.videoStore.URL(streamPrefix + "/" + stream.HLSPath)
+			blob.HLSURL = &hlsURL
+		}
+		if stream.DASHPath != "" {
+			dashURL := cfg.videoStore.URL(streamPrefix + "/" + stream.DASHPath)
+			blob.DashURL = &dashURL
+		}
+	}
+	if sprites.Dir != "" {
+		spritePrefix := strings.TrimSuffix(key, ".mp4") + "/sprites"
+		err = putDir(ctx, cfg.videoStore, sprites.Dir, spritePrefix)
+		if err != nil {
+			return database.Blob{}, fmt.Errorf("couldn't upload preview sprites: %w", err)
+		}
+		blob.PreviewSprites = cfg.previewSpritesFor(sprites, spritePrefix)
+	}
+	return blob, nil
 }
 
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	const uploadLimit = 10 << 30
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
 
@@ -82,18 +218,18 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.videos.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't find video", err)
+		respondWithDBError(w, "Couldn't find video", err)
 		return
 	}
 	if userID != video.UserID {
 		respondWithError(w, http.StatusUnauthorized, "You are not authorized to upload this video", err)
 		return
 	}
-	videoFile, header, err := r.FormFile("video")
+	videoFile, _, err := r.FormFile("video")
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't read video file", err)
 		return
 	}
 	defer func(videoFile multipart.File) {
@@ -102,191 +238,42 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 			log.Printf("Error closing file: %v", err)
 		}
 	}(videoFile)
-	mType := header.Header.Get("Content-Type")
-	mediaType, _, err := mime.ParseMediaType(mType)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid MediaType", err)
-		return
-	}
-	mimetype, _, err := mime.ParseMediaType(mediaType)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid MediaType", err)
-		return
-	}
-	if mimetype != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Invalid MediaType", err)
-		return
-	}
 
-	randInt, err := rand.Int(rand.Reader, big.NewInt(uploadLimit))
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error generating random video", err)
-		return
-	}
-	intRandInt := int(randInt.Int64())
-	tempVideoFile, err := os.CreateTemp("/tmp", "temp_video"+strconv.Itoa(intRandInt))
+	// The part's Content-Type is whatever the client claims; what the file
+	// actually is gets checked once it's on disk.
+	spoolFile, err := os.CreateTemp(cfg.processingSpoolDir, "upload-*.mp4")
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error creating temp video", err)
 		return
 	}
-	defer os.Remove(tempVideoFile.Name())
-	defer tempVideoFile.Close()
-	_, err = io.Copy(tempVideoFile, videoFile)
-	_, err = tempVideoFile.Seek(0, io.SeekStart)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error seeking temp video", err)
-		return
+	_, err = io.Copy(spoolFile, videoFile)
+	if err == nil {
+		err = spoolFile.Close()
+	} else {
+		spoolFile.Close()
 	}
-	randomBuf := make([]byte, 32)
-	_, err = rand.Read(randomBuf)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't generate random string", err)
+		os.Remove(spoolFile.Name())
+		respondWithError(w, http.StatusInternalServerError, "Error saving video", err)
 		return
 	}
-	ratio, err := getVideoAspectRatio(tempVideoFile.Name())
+	verr, err := cfg.validateVideoFile(r.Context(), spoolFile.Name())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't get video aspect ratio", err)
+		os.Remove(spoolFile.Name())
+		respondWithError(w, http.StatusInternalServerError, "Error validating video", err)
 		return
 	}
-	var aspectRatioPrefix string
-	switch ratio {
-	case "16:9":
-		aspectRatioPrefix = "landscape"
-	case "9:16":
-		aspectRatioPrefix = "portrait"
-	case "other":
-		aspectRatioPrefix = "other"
-	default:
-		respondWithError(w, http.StatusBadRequest, "Invalid Aspect Ratio", err)
+	if verr != nil {
+		os.Remove(spoolFile.Name())
+		respondWithValidationError(w, verr)
 		return
 	}
-	randomHexString := hex.EncodeToString(randomBuf)
-	var s3PutObjectInput *s3.PutObjectInput
-	bucket := cfg.s3Bucket
-	key := aspectRatioPrefix + "/" + fmt.Sprintf("%v.ext", randomHexString)
-	processedTempFilePath, err := processVideoForFastStart(tempVideoFile.Name())
+	job, err := cfg.enqueueProcessing(videoID, spoolFile.Name())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error processing video", err)
+		os.Remove(spoolFile.Name())
+		respondWithError(w, http.StatusInternalServerError, "Error queueing video for processing", err)
 		return
 	}
-	processedTempFile, err := os.Open(processedTempFilePath)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error opening processed temp file", err)
-		return
-	}
-	defer os.Remove(processedTempFile.Name())
-	defer processedTempFile.Close()
-	s3PutObjectInput = &s3.PutObjectInput{
-		Bucket:      &bucket,
-		Key:         &key,
-		Body:        processedTempFile,
-		ContentType: &mimetype,
-	}
-	_, err = cfg.s3Client.PutObject(context.Background(), s3PutObjectInput)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error uploading video", err)
-		return
-	}
-	newVideoUrl := fmt.Sprintf("%v/%v", cfg.s3CfDistribution, key)
-	err = cfg.db.UpdateVideo(database.Video{
-		ID:           videoID,
-		VideoURL:     &newVideoUrl,
-		ThumbnailURL: video.ThumbnailURL,
-		CreateVideoParams: database.CreateVideoParams{
-			Title:       video.Title,
-			Description: video.Description,
-			UserID:      userID,
-		},
-	})
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error uploading video", err)
-		return
-	}
-	video, err = cfg.db.GetVideo(videoID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error getting video", err)
-		return
-	}
-
-	log.Printf("Successfully uploaded video: %v, to s3 with key: %v", videoID, key)
-	w.Header().Set("Content-Type", "application/octet-stream")
-	respondWithJSON(w, http.StatusCreated, video)
-}
 
-type VideoMetaData struct {
-	Streams []struct {
-		Index              int    `json:"index"`
-		CodecName          string `json:"codec_name,omitempty"`
-		CodecLongName      string `json:"codec_long_name,omitempty"`
-		Profile            string `json:"profile,omitempty"`
-		CodecType          string `json:"codec_type"`
-		CodecTagString     string `json:"codec_tag_string"`
-		CodecTag           string `json:"codec_tag"`
-		Width              int    `json:"width,omitempty"`
-		Height             int    `json:"height,omitempty"`
-		CodedWidth         int    `json:"coded_width,omitempty"`
-		CodedHeight        int    `json:"coded_height,omitempty"`
-		ClosedCaptions     int    `json:"closed_captions,omitempty"`
-		FilmGrain          int    `json:"film_grain,omitempty"`
-		HasBFrames         int    `json:"has_b_frames,omitempty"`
-		SampleAspectRatio  string `json:"sample_aspect_ratio,omitempty"`
-		DisplayAspectRatio string `json:"display_aspect_ratio,omitempty"`
-		PixFmt             string `json:"pix_fmt,omitempty"`
-		Level              int    `json:"level,omitempty"`
-		ColorRange         string `json:"color_range,omitempty"`
-		ColorSpace         string `json:"color_space,omitempty"`
-		ColorTransfer      string `json:"color_transfer,omitempty"`
-		ColorPrimaries     string `json:"color_primaries,omitempty"`
-		ChromaLocation     string `json:"chroma_location,omitempty"`
-		FieldOrder         string `json:"field_order,omitempty"`
-		Refs               int    `json:"refs,omitempty"`
-		IsAvc              string `json:"is_avc,omitempty"`
-		NalLengthSize      string `json:"nal_length_size,omitempty"`
-		Id                 string `json:"id"`
-		RFrameRate         string `json:"r_frame_rate"`
-		AvgFrameRate       string `json:"avg_frame_rate"`
-		TimeBase           string `json:"time_base"`
-		StartPts           int    `json:"start_pts"`
-		StartTime          string `json:"start_time"`
-		DurationTs         int    `json:"duration_ts"`
-		Duration           string `json:"duration"`
-		BitRate            string `json:"bit_rate,omitempty"`
-		BitsPerRawSample   string `json:"bits_per_raw_sample,omitempty"`
-		NbFrames           string `json:"nb_frames"`
-		ExtradataSize      int    `json:"extradata_size"`
-		Disposition        struct {
-			Default         int `json:"default"`
-			Dub             int `json:"dub"`
-			Original        int `json:"original"`
-			Comment         int `json:"comment"`
-			Lyrics          int `json:"lyrics"`
-			Karaoke         int `json:"karaoke"`
-			Forced          int `json:"forced"`
-			HearingImpaired int `json:"hearing_impaired"`
-			VisualImpaired  int `json:"visual_impaired"`
-			CleanEffects    int `json:"clean_effects"`
-			AttachedPic     int `json:"attached_pic"`
-			TimedThumbnails int `json:"timed_thumbnails"`
-			NonDiegetic     int `json:"non_diegetic"`
-			Captions        int `json:"captions"`
-			Descriptions    int `json:"descriptions"`
-			Metadata        int `json:"metadata"`
-			Dependent       int `json:"dependent"`
-			StillImage      int `json:"still_image"`
-			Multilayer      int `json:"multilayer"`
-		} `json:"disposition"`
-		Tags struct {
-			Language    string `json:"language"`
-			HandlerName string `json:"handler_name"`
-			VendorId    string `json:"vendor_id,omitempty"`
-			Encoder     string `json:"encoder,omitempty"`
-			Timecode    string `json:"timecode,omitempty"`
-		} `json:"tags"`
-		SampleFmt      string `json:"sample_fmt,omitempty"`
-		SampleRate     string `json:"sample_rate,omitempty"`
-		Channels       int    `json:"channels,omitempty"`
-		ChannelLayout  string `json:"channel_layout,omitempty"`
-		BitsPerSample  int    `json:"bits_per_sample,omitempty"`
-		InitialPadding int    `json:"initial_padding,omitempty"`
-	} `json:"streams"`
+	respondWithJSON(w, http.StatusAccepted, job)
 }
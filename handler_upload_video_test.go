@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+// mp4Header is enough of an MP4 file for the container sniffing.
+var mp4Header = append([]byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00isomiso2avc1mp41"), make([]byte, 1024)...)
+
+func newUploadRequest(t *testing.T, cfg *apiConfig, video database.Video, data []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("video", "upload.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+
+	token, err := auth.MakeJWT(video.UserID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/video_upload/"+video.ID.String(), &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.SetPathValue("videoID", video.ID.String())
+	return req
+}
+
+// processNextJob does what a processing worker does with the oldest job.
+func processNextJob(t *testing.T, cfg *apiConfig) database.ProcessingJob {
+	t.Helper()
+	job, ok, err := cfg.processingJobs.ClaimProcessingJob(time.Now().UTC().Add(processingLease))
+	if err != nil || !ok {
+		t.Fatalf("couldn't claim processing job: ok %v, err %v", ok, err)
+	}
+	cfg.runProcessingJob(job)
+	job, err = cfg.processingJobs.GetProcessingJob(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestHandlerUploadVideo(t *testing.T) {
+	fake := &media.Fake{
+		// MPEG-2 has to be transcoded, and its anamorphic pixels make a
+		// 720x480 frame display at 16:9.
+		Metadata: loadProbe(t, "anamorphic.json"),
+		RunFunc: func(args []string, stdin io.Reader, stdout io.Writer) error {
+			return os.WriteFile(args[len(args)-1], []byte("transcoded"), 0644)
+		},
+	}
+	cfg := newTestConfig(t, fake)
+	video := newTestVideo(t, cfg)
+
+	w := httptest.NewRecorder()
+	cfg.handlerUploadVideo(w, newUploadRequest(t, cfg, video, mp4Header))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("upload returned %d: %s", w.Code, w.Body)
+	}
+	var queued database.ProcessingJob
+	if err := json.NewDecoder(w.Body).Decode(&queued); err != nil {
+		t.Fatal(err)
+	}
+	if queued.State != database.ProcessingStateQueued {
+		t.Errorf("upload returned a %s job, want queued", queued.State)
+	}
+
+	job := processNextJob(t, cfg)
+	if job.State != database.ProcessingStateReady {
+		t.Fatalf("job is %s (error %v), want ready", job.State, job.Error)
+	}
+	if _, err := os.Stat(job.SourcePath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("spooled upload wasn't removed: %v", err)
+	}
+
+	// Validation, the pipeline, and the re-encoded output are each probed.
+	probes := fake.Probes()
+	if len(probes) != 3 || probes[0] != job.SourcePath || probes[1] != job.SourcePath || !strings.HasSuffix(probes[2], ".processing") {
+		t.Errorf("probed %v", probes)
+	}
+	runs := fake.Runs()
+	if len(runs) != 1 {
+		t.Fatalf("ran ffmpeg %d times, want once to normalize", len(runs))
+	}
+	args := runs[0]
+	if !slices.Contains(args, job.SourcePath) || !slices.Contains(args, "libx264") {
+		t.Errorf("normalize didn't re-encode the upload: %v", args)
+	}
+
+	video, err := cfg.videos.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.VideoKey == nil || !strings.HasPrefix(*video.VideoKey, "landscape/") {
+		t.Errorf("video stored under %v, want the landscape prefix", video.VideoKey)
+	}
+	if video.VideoURL == nil || *video.VideoURL != cfg.videoStore.URL(*video.VideoKey) {
+		t.Errorf("video URL %v doesn't point at key %v", video.VideoURL, video.VideoKey)
+	}
+	if video.Metadata == nil || video.Metadata.VideoCodec != "mpeg2video" {
+		t.Errorf("metadata %+v, want the probed codec", video.Metadata)
+	}
+	stored, _, err := cfg.videoStore.Get(t.Context(), *video.VideoKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(stored)
+	stored.Close()
+	if string(data) != "transcoded" {
+		t.Errorf("stored %q, want ffmpeg's output", data)
+	}
+}
+
+func TestHandlerUploadVideoRejectsWithoutVideoStream(t *testing.T) {
+	metadata := loadProbe(t, "audio_first.json")
+	metadata.Streams = metadata.Streams[:1]
+	fake := &media.Fake{Metadata: metadata}
+	cfg := newTestConfig(t, fake)
+	video := newTestVideo(t, cfg)
+
+	w := httptest.NewRecorder()
+	cfg.handlerUploadVideo(w, newUploadRequest(t, cfg, video, mp4Header))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("upload returned %d, want 422", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "no_video_stream") {
+		t.Errorf("body %s doesn't give the reason", w.Body)
+	}
+	if len(fake.Runs()) != 0 {
+		t.Errorf("ran ffmpeg for a rejected upload: %v", fake.Runs())
+	}
+	if _, err := cfg.processingJobs.GetLatestProcessingJob(video.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("rejected upload was queued: %v", err)
+	}
+}
+
+func TestProcessingJobKeepsFFmpegError(t *testing.T) {
+	fake := &media.Fake{
+		Metadata: loadProbe(t, "anamorphic.json"),
+		RunFunc: func(args []string, stdin io.Reader, stdout io.Writer) error {
+			return &media.Error{
+				Tool:   "ffmpeg",
+				Err:    errors.New("exit status 1"),
+				Stderr: "Error while decoding stream #0:0: Invalid data found when processing input",
+			}
+		},
+	}
+	cfg := newTestConfig(t, fake)
+	video := newTestVideo(t, cfg)
+
+	w := httptest.NewRecorder()
+	cfg.handlerUploadVideo(w, newUploadRequest(t, cfg, video, mp4Header))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("upload returned %d: %s", w.Code, w.Body)
+	}
+	var job database.ProcessingJob
+	for range maxProcessingAttempts {
+		job = processNextJob(t, cfg)
+	}
+	if job.State != database.ProcessingStateFailed || job.Error == nil {
+		t.Fatalf("job is %s with error %v, want failed", job.State, job.Error)
+	}
+	if !strings.Contains(*job.Error, "Invalid data found when processing input") {
+		t.Errorf("job error %q lost ffmpeg's stderr", *job.Error)
+	}
+}
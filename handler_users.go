@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
@@ -33,10 +34,14 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user, err := cfg.db.CreateUser(database.CreateUserParams{
+	user, err := cfg.users.CreateUser(database.CreateUserParams{
 		Email:    params.Email,
 		Password: hashedPassword,
 	})
+	if errors.Is(err, database.ErrConflict) {
+		respondWithError(w, http.StatusConflict, "Email is already registered", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
 		return
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveJSON(t *testing.T, handler http.HandlerFunc, method, body, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func TestUserAndSessionHandlers(t *testing.T) {
+	cfg := newMemoryTestConfig(t)
+	credentials := `{"email": "walt@example.com", "password": "hunter2"}`
+
+	if w := serveJSON(t, cfg.handlerUsersCreate, http.MethodPost, credentials, ""); w.Code != http.StatusCreated {
+		t.Fatalf("signup returned %d: %s", w.Code, w.Body)
+	}
+	if w := serveJSON(t, cfg.handlerUsersCreate, http.MethodPost, credentials, ""); w.Code != http.StatusConflict {
+		t.Errorf("second signup with the same email returned %d, want 409", w.Code)
+	}
+
+	wrong := `{"email": "walt@example.com", "password": "wrong"}`
+	if w := serveJSON(t, cfg.handlerLogin, http.MethodPost, wrong, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("login with the wrong password returned %d, want 401", w.Code)
+	}
+	unknown := `{"email": "jesse@example.com", "password": "hunter2"}`
+	if w := serveJSON(t, cfg.handlerLogin, http.MethodPost, unknown, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("login with an unknown email returned %d, want 401", w.Code)
+	}
+
+	w := serveJSON(t, cfg.handlerLogin, http.MethodPost, credentials, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("login returned %d: %s", w.Code, w.Body)
+	}
+	var session struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&session); err != nil {
+		t.Fatal(err)
+	}
+	if session.Token == "" || session.RefreshToken == "" {
+		t.Fatalf("login returned session %+v", session)
+	}
+
+	if w := serveJSON(t, cfg.handlerRefresh, http.MethodPost, "", session.RefreshToken); w.Code != http.StatusOK {
+		t.Errorf("refresh returned %d: %s", w.Code, w.Body)
+	}
+	if w := serveJSON(t, cfg.handlerRefresh, http.MethodPost, "", "not-a-token"); w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh with an unknown token returned %d, want 401", w.Code)
+	}
+	if w := serveJSON(t, cfg.handlerRevoke, http.MethodPost, "", session.RefreshToken); w.Code != http.StatusNoContent {
+		t.Errorf("revoke returned %d: %s", w.Code, w.Body)
+	}
+	if w := serveJSON(t, cfg.handlerRevoke, http.MethodPost, "", "not-a-token"); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoking an unknown token returned %d, want 401", w.Code)
+	}
+}
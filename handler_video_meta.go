@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
@@ -34,7 +35,7 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 	}
 	params.UserID = userID
 
-	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
+	video, err := cfg.videos.CreateVideo(params.CreateVideoParams)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
@@ -62,9 +63,9 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.videos.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithDBError(w, "Couldn't get video", err)
 		return
 	}
 	if video.UserID != userID {
@@ -72,11 +73,34 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	versions, err := cfg.versions.GetVideoVersions(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video versions", err)
 		return
 	}
+	cleanup := videoCleanup(video)
+	// The current version's objects are the video's own; replaced versions
+	// hold on to theirs until they expire.
+	for _, version := range versions {
+		if !version.Current && version.ContentHash == nil && version.VideoKey != nil {
+			cleanup = append(cleanup, videoObjectCleanup(*version.VideoKey)...)
+		}
+	}
+	err = cfg.videoDeleter.DeleteVideo(videoID, cleanup)
+	if err != nil {
+		respondWithDBError(w, "Couldn't delete video", err)
+		return
+	}
+	if video.ContentHash != nil {
+		cfg.releaseBlob(*video.ContentHash)
+	}
+	for _, version := range versions {
+		if !version.Current && version.ContentHash != nil {
+			cfg.releaseBlob(*version.ContentHash)
+		}
+	}
+	cfg.wakeCleanup()
+	delete(videoThumbnails, videoID)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -89,9 +113,9 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.videos.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithDBError(w, "Couldn't get video", err)
 		return
 	}
 	respondWithJSON(w, http.StatusOK, video)
@@ -109,10 +133,20 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	query, err := parseVideoQuery(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid query", err)
+		return
+	}
+
+	page, err := cfg.videos.GetVideos(userID, query)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
-	respondWithJSON(w, http.StatusOK, videos)
+	respondWithJSON(w, http.StatusOK, page)
 }
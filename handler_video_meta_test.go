@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestVideoMetaHandlers(t *testing.T) {
+	cfg := newMemoryTestConfig(t)
+	token, err := auth.MakeJWT(uuid.New(), cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serveJSON(t, cfg.handlerVideoMetaCreate, http.MethodPost, `{"title": "Boots", "description": "A bear"}`, token)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", w.Code, w.Body)
+	}
+	var created database.Video
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/videos/"+id, nil)
+		req.SetPathValue("videoID", id)
+		w := httptest.NewRecorder()
+		cfg.handlerVideoGet(w, req)
+		return w
+	}
+	w = get(created.ID.String())
+	if w.Code != http.StatusOK {
+		t.Fatalf("get returned %d: %s", w.Code, w.Body)
+	}
+	var video database.Video
+	if err := json.NewDecoder(w.Body).Decode(&video); err != nil {
+		t.Fatal(err)
+	}
+	if video.ID != created.ID || video.Title != "Boots" {
+		t.Errorf("got %+v, want the created video", video)
+	}
+	if w := get(uuid.NewString()); w.Code != http.StatusNotFound {
+		t.Errorf("get of an unknown video returned %d, want 404", w.Code)
+	}
+
+	w = serveJSON(t, cfg.handlerVideosRetrieve, http.MethodGet, "", token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("list returned %d: %s", w.Code, w.Body)
+	}
+	var page database.VideoPage
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Videos) != 1 || page.Videos[0].ID != created.ID {
+		t.Errorf("listed %+v, want just the created video", page.Videos)
+	}
+}
@@ -17,7 +17,8 @@ import (
 type TokenType string
 
 const (
-	TokenTypeAccess TokenType = "tubely-access"
+	TokenTypeAccess       TokenType = "tubely-access"
+	TokenTypeDirectUpload TokenType = "tubely-direct-upload"
 )
 
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
@@ -80,6 +81,58 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	return id, nil
 }
 
+type DirectUploadClaims struct {
+	jwt.RegisteredClaims
+	VideoID  string `json:"video_id"`
+	Key      string `json:"key"`
+	UploadID string `json:"upload_id,omitempty"`
+}
+
+// MakeDirectUploadToken signs the storage key (and multipart upload ID, if
+// any) handed out for a presigned upload so the completion request can be
+// trusted. The token's ID is the server-side record of the upload, which is
+// what makes completing it single-use.
+func MakeDirectUploadToken(
+	id uuid.UUID,
+	userID uuid.UUID,
+	videoID uuid.UUID,
+	key string,
+	uploadID string,
+	tokenSecret string,
+	expiresIn time.Duration,
+) (string, error) {
+	signingKey := []byte(tokenSecret)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, DirectUploadClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeDirectUpload),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+			ID:        id.String(),
+		},
+		VideoID:  videoID.String(),
+		Key:      key,
+		UploadID: uploadID,
+	})
+	return token.SignedString(signingKey)
+}
+
+func ValidateDirectUploadToken(tokenString, tokenSecret string) (DirectUploadClaims, error) {
+	claims := DirectUploadClaims{}
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+	)
+	if err != nil {
+		return DirectUploadClaims{}, err
+	}
+	if claims.Issuer != string(TokenTypeDirectUpload) {
+		return DirectUploadClaims{}, errors.New("invalid issuer")
+	}
+	return claims, nil
+}
+
 func GetBearerToken(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
@@ -0,0 +1,296 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Blob is a processed video stored once under a content-addressed key and
+// shared by every video whose upload produced the same bytes. The streaming
+// and preview URLs are derived from the same bytes, so they are shared too.
+type Blob struct {
+	Hash           string          `json:"sha256"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+	Key            string          `json:"-"`
+	Size           int64           `json:"size"`
+	HLSURL         *string         `json:"hls_url"`
+	DashURL        *string         `json:"dash_url"`
+	PreviewSprites *PreviewSprites `json:"preview_sprites"`
+	RefCount       int             `json:"ref_count"`
+}
+
+const blobColumns = `
+	hash,
+	created_at,
+	updated_at,
+	storage_key,
+	size,
+	hls_url,
+	dash_url,
+	preview_sprites,
+	ref_count
+`
+
+func scanBlob(row rowScanner) (Blob, error) {
+	var blob Blob
+	err := row.Scan(
+		&blob.Hash,
+		&blob.CreatedAt,
+		&blob.UpdatedAt,
+		&blob.Key,
+		&blob.Size,
+		&blob.HLSURL,
+		&blob.DashURL,
+		&blob.PreviewSprites,
+		&blob.RefCount,
+	)
+	return blob, err
+}
+
+// GetBlob returns the blob with the given SHA-256, or ErrNotFound if no
+// video references those bytes.
+func (c Client) GetBlob(hash string) (Blob, error) {
+	query := `SELECT ` + blobColumns + `
+	FROM video_blobs
+	WHERE hash = ?
+	`
+	blob, err := scanBlob(c.db.QueryRow(query, hash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Blob{}, ErrNotFound
+		}
+		return Blob{}, err
+	}
+	return blob, nil
+}
+
+// GetBlobs returns every stored blob.
+func (c Client) GetBlobs() ([]Blob, error) {
+	query := `SELECT ` + blobColumns + `
+	FROM video_blobs
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blobs := []Blob{}
+	for rows.Next() {
+		blob, err := scanBlob(rows)
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, rows.Err()
+}
+
+// GetUserBlob returns the blob hash names, as either an upload's SHA-256 or
+// the blob's own, but only if one of userID's videos or their earlier
+// versions uses it. Anyone can learn a hash; only the owner of the content
+// gets to reuse it. It returns ErrNotFound otherwise.
+func (c Client) GetUserBlob(userID uuid.UUID, hash string) (Blob, error) {
+	query := `SELECT ` + blobColumns + `
+	FROM video_blobs b
+	WHERE (
+		b.hash = ?
+		OR b.hash = (SELECT hash FROM video_blob_sources WHERE source_hash = ?)
+	) AND (
+		EXISTS (
+			SELECT 1 FROM videos v
+			WHERE v.user_id = ? AND v.content_hash = b.hash
+		) OR EXISTS (
+			SELECT 1 FROM video_versions vv
+			JOIN videos v ON v.id = vv.video_id
+			WHERE v.user_id = ? AND vv.content_hash = b.hash
+		)
+	)
+	LIMIT 1
+	`
+	blob, err := scanBlob(c.db.QueryRow(query, hash, hash, userID, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Blob{}, ErrNotFound
+		}
+		return Blob{}, err
+	}
+	return blob, nil
+}
+
+// AcquireBlob records blob, just stored under a key of its own, with one
+// reference and returns the stored row. If the same content was stored
+// concurrently the existing row wins and gets the reference instead; its
+// Key then differs from blob.Key, whose objects nobody needs.
+func (c Client) AcquireBlob(blob Blob) (Blob, error) {
+	query := `
+	INSERT INTO video_blobs (
+		hash,
+		created_at,
+		updated_at,
+		storage_key,
+		size,
+		hls_url,
+		dash_url,
+		preview_sprites,
+		ref_count
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, 1)
+	ON CONFLICT (hash) DO UPDATE SET
+		updated_at = CURRENT_TIMESTAMP,
+		ref_count = video_blobs.ref_count + 1
+	RETURNING ` + blobColumns
+	return scanBlob(c.db.QueryRow(
+		query,
+		blob.Hash,
+		blob.Key,
+		blob.Size,
+		blob.HLSURL,
+		blob.DashURL,
+		blob.PreviewSprites,
+	))
+}
+
+// ReuseBlob takes another reference on the blob hash, as long as it is still
+// stored under key. Once the last reference is released the row goes and
+// key is queued for deletion, so a blob looked up before that must not be
+// brought back: ReuseBlob returns ErrNotFound and the content has to be
+// stored again.
+func (c Client) ReuseBlob(hash, key string) (Blob, error) {
+	query := `
+	UPDATE video_blobs
+	SET
+		ref_count = ref_count + 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE hash = ? AND storage_key = ?
+	RETURNING ` + blobColumns
+	blob, err := scanBlob(c.db.QueryRow(query, hash, key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Blob{}, ErrNotFound
+		}
+		return Blob{}, err
+	}
+	return blob, nil
+}
+
+// ReleaseBlob drops a reference on hash and returns the blob as it was left.
+// When the last reference goes the row is removed, RefCount is 0 and the
+// tasks cleanup returns for the blob's key are queued in the same
+// transaction. It returns ErrNotFound if nothing references hash.
+func (c Client) ReleaseBlob(hash string, cleanup func(key string) []CleanupTask) (Blob, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Blob{}, err
+	}
+	defer tx.Rollback()
+	blob, err := releaseBlob(tx, hash, cleanup)
+	if err != nil {
+		return Blob{}, err
+	}
+	return blob, tx.Commit()
+}
+
+func releaseBlob(tx *txn, hash string, cleanup func(key string) []CleanupTask) (Blob, error) {
+	query := `
+	UPDATE video_blobs
+	SET
+		ref_count = ref_count - 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE hash = ?
+	RETURNING ` + blobColumns
+	blob, err := scanBlob(tx.QueryRow(query, hash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Blob{}, ErrNotFound
+		}
+		return Blob{}, err
+	}
+	if blob.RefCount <= 0 {
+		blob.RefCount = 0
+		if _, err := tx.Exec("DELETE FROM video_blob_sources WHERE hash = ?", hash); err != nil {
+			return Blob{}, err
+		}
+		if _, err := tx.Exec("DELETE FROM video_blobs WHERE hash = ?", hash); err != nil {
+			return Blob{}, err
+		}
+		err = insertCleanupTasks(tx, cleanup(blob.Key))
+		if err != nil {
+			return Blob{}, err
+		}
+	}
+	return blob, nil
+}
+
+// AddBlobSource remembers that an upload with sourceHash was processed into
+// the blob hash, so the same upload can be recognised before it is sent.
+func (c Client) AddBlobSource(sourceHash, hash string) error {
+	query := `
+	INSERT INTO video_blob_sources (source_hash, hash, created_at)
+	VALUES (?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT (source_hash) DO UPDATE SET hash = excluded.hash
+	`
+	_, err := c.db.Exec(query, sourceHash, hash)
+	return err
+}
+
+// CopyVideoMetadata gives videoID the technical metadata of another video
+// that shares the blob hash. It does nothing if there is none.
+func (c Client) CopyVideoMetadata(hash string, videoID uuid.UUID) error {
+	query := `
+	INSERT INTO video_metadata (
+		video_id,
+		created_at,
+		updated_at,
+		duration,
+		width,
+		height,
+		video_codec,
+		audio_codec,
+		frame_rate,
+		bit_rate,
+		audio_channels,
+		audio_sample_rate,
+		rotation,
+		container
+	)
+	SELECT
+		?,
+		CURRENT_TIMESTAMP,
+		CURRENT_TIMESTAMP,
+		m.duration,
+		m.width,
+		m.height,
+		m.video_codec,
+		m.audio_codec,
+		m.frame_rate,
+		m.bit_rate,
+		m.audio_channels,
+		m.audio_sample_rate,
+		m.rotation,
+		m.container
+	FROM video_metadata m
+	JOIN videos v ON v.id = m.video_id
+	WHERE v.content_hash = ? AND v.id != ?
+	LIMIT 1
+	ON CONFLICT (video_id) DO UPDATE SET
+		updated_at = CURRENT_TIMESTAMP,
+		duration = excluded.duration,
+		width = excluded.width,
+		height = excluded.height,
+		video_codec = excluded.video_codec,
+		audio_codec = excluded.audio_codec,
+		frame_rate = excluded.frame_rate,
+		bit_rate = excluded.bit_rate,
+		audio_channels = excluded.audio_channels,
+		audio_sample_rate = excluded.audio_sample_rate,
+		rotation = excluded.rotation,
+		container = excluded.container
+	`
+	_, err := c.db.Exec(query, videoID, hash, videoID)
+	return err
+}
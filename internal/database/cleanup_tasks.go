@@ -0,0 +1,141 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Stores a CleanupTask can target.
+const (
+	CleanupStoreVideo     = "video"
+	CleanupStoreThumbnail = "thumbnail"
+)
+
+// CleanupTask is a stored object, or with Prefix every object under Key,
+// that has to be deleted. A task stays queued until the delete succeeds.
+type CleanupTask struct {
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Store         string    `json:"store"`
+	Key           string    `json:"key"`
+	Prefix        bool      `json:"prefix"`
+	Attempts      int       `json:"attempts"`
+	LastError     *string   `json:"last_error"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}
+
+const cleanupTaskColumns = `
+	id,
+	created_at,
+	updated_at,
+	store,
+	key,
+	prefix,
+	attempts,
+	last_error,
+	next_attempt_at
+`
+
+func scanCleanupTask(row rowScanner) (CleanupTask, error) {
+	var task CleanupTask
+	err := row.Scan(
+		&task.ID,
+		&task.CreatedAt,
+		&task.UpdatedAt,
+		&task.Store,
+		&task.Key,
+		&task.Prefix,
+		&task.Attempts,
+		&task.LastError,
+		&task.NextAttemptAt,
+	)
+	return task, err
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func insertCleanupTasks(db execer, tasks []CleanupTask) error {
+	query := `
+	INSERT INTO cleanup_tasks (
+		id,
+		created_at,
+		updated_at,
+		store,
+		key,
+		prefix,
+		attempts,
+		next_attempt_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, 0, ?)
+	`
+	now := time.Now().UTC()
+	for _, task := range tasks {
+		_, err := db.Exec(query, uuid.New(), task.Store, task.Key, task.Prefix, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateCleanupTasks queues tasks to run as soon as possible.
+func (c Client) CreateCleanupTasks(tasks []CleanupTask) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := insertCleanupTasks(tx, tasks); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetDueCleanupTasks returns up to limit tasks whose next attempt is due,
+// oldest first.
+func (c Client) GetDueCleanupTasks(now time.Time, limit int) ([]CleanupTask, error) {
+	query := `SELECT ` + cleanupTaskColumns + `
+	FROM cleanup_tasks
+	WHERE next_attempt_at <= ?
+	ORDER BY next_attempt_at, created_at
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []CleanupTask{}
+	for rows.Next() {
+		task, err := scanCleanupTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+// CompleteCleanupTask removes a task whose delete succeeded.
+func (c Client) CompleteCleanupTask(id uuid.UUID) error {
+	return expectRows(c.db.Exec("DELETE FROM cleanup_tasks WHERE id = ?", id))
+}
+
+// RetryCleanupTask records a failed attempt and when to try again.
+func (c Client) RetryCleanupTask(id uuid.UUID, errMsg string, next time.Time) error {
+	query := `
+	UPDATE cleanup_tasks
+	SET
+		attempts = attempts + 1,
+		last_error = ?,
+		next_attempt_at = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	return expectRows(c.db.Exec(query, errMsg, next.UTC(), id))
+}
@@ -3,87 +3,86 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Client struct {
-	db *sql.DB
+	db *conn
 }
 
-func NewClient(pathToDB string) (Client, error) {
-	db, err := sql.Open("sqlite3", pathToDB)
-	if err != nil {
-		return Client{}, err
+// NewClient opens the database DSN points at: a postgres:// URL, or else a
+// SQLite file. It doesn't touch the schema; see MigrateUp.
+func NewClient(dsn string) (Client, error) {
+	d := dialectFor(dsn)
+	driver := "postgres"
+	if d == dialectSQLite {
+		driver = "sqlite3"
+		// Background workers write concurrently with request handlers, so
+		// wait for SQLite's write lock instead of failing with "database is
+		// locked".
+		if !strings.Contains(dsn, "_busy_timeout") {
+			sep := "?"
+			if strings.Contains(dsn, "?") {
+				sep = "&"
+			}
+			dsn += sep + "_busy_timeout=5000"
+		}
 	}
-	c := Client{db}
-	err = c.autoMigrate()
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		return Client{}, err
 	}
-	return c, nil
-
+	return Client{&conn{DB: db, dialect: d}}, nil
 }
 
-func (c *Client) autoMigrate() error {
-	userTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		password TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL
-	);
-	`
-	_, err := c.db.Exec(userTable)
-	if err != nil {
-		return err
+func (c Client) Reset() error {
+	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
-	refreshTokenTable := `
-	CREATE TABLE IF NOT EXISTS refresh_tokens (
-		token TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		revoked_at TIMESTAMP,
-		user_id TEXT NOT NULL,
-		expires_at TIMESTAMP NOT NULL,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(refreshTokenTable)
-	if err != nil {
-		return err
+	if _, err := c.db.Exec("DELETE FROM processing_jobs"); err != nil {
+		return fmt.Errorf("failed to reset table processing_jobs: %w", err)
 	}
-
-	videoTable := `
-	CREATE TABLE IF NOT EXISTS videos (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		title TEXT NOT NULL,
-		description TEXT,
-		thumbnail_url TEXT,
-		video_url TEXT TEXT,
-		user_id INTEGER,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(videoTable)
-	if err != nil {
-		return err
+	if _, err := c.db.Exec("DELETE FROM video_metadata"); err != nil {
+		return fmt.Errorf("failed to reset table video_metadata: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_versions"); err != nil {
+		return fmt.Errorf("failed to reset table video_versions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM direct_uploads"); err != nil {
+		return fmt.Errorf("failed to reset table direct_uploads: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM cleanup_tasks"); err != nil {
+		return fmt.Errorf("failed to reset table cleanup_tasks: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_blob_sources"); err != nil {
+		return fmt.Errorf("failed to reset table video_blob_sources: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_blobs"); err != nil {
+		return fmt.Errorf("failed to reset table video_blobs: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
+		return fmt.Errorf("failed to reset table videos: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
+		return fmt.Errorf("failed to reset table users: %w", err)
 	}
 	return nil
 }
 
-func (c Client) Reset() error {
-	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
-		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
+// expectRows turns a write that matched no rows into ErrNotFound.
+func expectRows(result sql.Result, err error) error {
+	if err != nil {
+		return err
 	}
-	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
-		return fmt.Errorf("failed to reset table users: %w", err)
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
 	}
-	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
-		return fmt.Errorf("failed to reset table videos: %w", err)
+	if n == 0 {
+		return ErrNotFound
 	}
 	return nil
 }
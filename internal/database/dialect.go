@@ -0,0 +1,139 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+type dialect string
+
+const (
+	dialectSQLite   dialect = "sqlite"
+	dialectPostgres dialect = "postgres"
+)
+
+// dialectFor picks the engine from the DSN scheme. Anything that isn't a
+// postgres:// URL is a SQLite path or file: URI.
+func dialectFor(dsn string) dialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return dialectPostgres
+	}
+	return dialectSQLite
+}
+
+// rebind rewrites the ? placeholders queries are written with into $1, $2,
+// ... for Postgres. Question marks inside string literals are left alone.
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// skipLocked is appended to the subquery that picks a row for a worker to
+// claim, so that on Postgres concurrent workers, possibly in other
+// replicas, each get a different row. SQLite serializes writers anyway.
+func (d dialect) skipLocked() string {
+	if d == dialectPostgres {
+		return "FOR UPDATE SKIP LOCKED"
+	}
+	return ""
+}
+
+// sqliteTime is the text format SQLite's CURRENT_TIMESTAMP writes, with
+// any fraction of a second after it.
+const sqliteTime = "2006-01-02 15:04:05.999999999"
+
+// timeArg binds t for comparing with a column set to CURRENT_TIMESTAMP.
+// SQLite stores those as "YYYY-MM-DD HH:MM:SS" text and compares them as
+// text, so t has to be bound in that format to compare correctly. A whole
+// second binds exactly as CURRENT_TIMESTAMP writes it; a fraction follows
+// it and still sorts correctly, so timeArg is also how to store a time more
+// precise than CURRENT_TIMESTAMP.
+func (d dialect) timeArg(t time.Time) any {
+	if d == dialectSQLite {
+		return t.UTC().Format(sqliteTime)
+	}
+	return t
+}
+
+// conn is the database handle the Client queries through. It takes queries
+// in the ? placeholder style the package is written in and rebinds them for
+// the dialect.
+type conn struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (c *conn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.DB.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.DB.Query(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) QueryRow(query string, args ...any) *sql.Row {
+	return c.DB.QueryRow(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) Begin() (*txn, error) {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &txn{Tx: tx, dialect: c.dialect}, nil
+}
+
+// txn is a transaction on a conn, rebinding queries the same way.
+type txn struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (t *txn) Exec(query string, args ...any) (sql.Result, error) {
+	return t.Tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t *txn) Query(query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t *txn) QueryRow(query string, args ...any) *sql.Row {
+	return t.Tx.QueryRow(t.dialect.rebind(query), args...)
+}
+
+// isUniqueViolation reports whether err is either engine rejecting a
+// duplicate primary key or unique column.
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	return false
+}
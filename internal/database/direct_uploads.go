@@ -0,0 +1,99 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DirectUpload is an upload a client was given presigned URLs for. Its ID
+// goes into the upload token, and CompletedAt is set once the upload has
+// been queued for processing.
+type DirectUpload struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	VideoID     uuid.UUID  `json:"video_id"`
+	Key         string     `json:"key"`
+}
+
+func (c Client) CreateDirectUpload(videoID uuid.UUID, key string) (DirectUpload, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO direct_uploads (
+		id,
+		created_at,
+		video_id,
+		key
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, videoID, key)
+	if err != nil {
+		return DirectUpload{}, err
+	}
+	return c.GetDirectUpload(id)
+}
+
+func (c Client) GetDirectUpload(id uuid.UUID) (DirectUpload, error) {
+	query := `
+	SELECT id, created_at, completed_at, video_id, key
+	FROM direct_uploads
+	WHERE id = ?
+	`
+	var upload DirectUpload
+	err := c.db.QueryRow(query, id).Scan(
+		&upload.ID,
+		&upload.CreatedAt,
+		&upload.CompletedAt,
+		&upload.VideoID,
+		&upload.Key,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DirectUpload{}, ErrNotFound
+		}
+		return DirectUpload{}, err
+	}
+	return upload, nil
+}
+
+// CompleteDirectUpload marks the upload completed and queues a processing
+// job for its spooled copy at sourcePath, in one transaction. It returns
+// ErrConflict if the upload was completed already.
+func (c Client) CompleteDirectUpload(id uuid.UUID, sourcePath string) (ProcessingJob, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return ProcessingJob{}, err
+	}
+	defer tx.Rollback()
+	var videoID uuid.UUID
+	err = tx.QueryRow("SELECT video_id FROM direct_uploads WHERE id = ?", id).Scan(&videoID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ProcessingJob{}, ErrNotFound
+		}
+		return ProcessingJob{}, err
+	}
+	query := `
+	UPDATE direct_uploads
+	SET completed_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND completed_at IS NULL
+	`
+	err = expectRows(tx.Exec(query, id))
+	if errors.Is(err, ErrNotFound) {
+		return ProcessingJob{}, ErrConflict
+	}
+	if err != nil {
+		return ProcessingJob{}, err
+	}
+	jobID, err := insertProcessingJob(tx, c.db.dialect, videoID, sourcePath)
+	if err != nil {
+		return ProcessingJob{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ProcessingJob{}, err
+	}
+	return c.GetProcessingJob(jobID)
+}
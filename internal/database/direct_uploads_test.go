@@ -0,0 +1,53 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCompleteDirectUpload(t *testing.T) {
+	for name, c := range newTestClients(t) {
+		t.Run(name, func(t *testing.T) {
+			user, err := c.CreateUser(CreateUserParams{Email: "direct@example.com", Password: "hash"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			video, err := c.CreateVideo(CreateVideoParams{Title: "Test", UserID: user.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			upload, err := c.CreateDirectUpload(video.ID, "landscape/upload.mp4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if upload.CompletedAt != nil {
+				t.Errorf("new upload is completed: %+v", upload)
+			}
+
+			job, err := c.CompleteDirectUpload(upload.ID, "/spool/upload.mp4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if job.VideoID != video.ID || job.State != ProcessingStateQueued {
+				t.Errorf("completing queued %+v", job)
+			}
+			if _, err := c.CompleteDirectUpload(upload.ID, "/spool/again.mp4"); !errors.Is(err, ErrConflict) {
+				t.Errorf("completing a second time returned %v, want ErrConflict", err)
+			}
+			latest, err := c.GetLatestProcessingJob(video.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if latest.ID != job.ID {
+				t.Errorf("a second completion queued job %v", latest.ID)
+			}
+			upload, err = c.GetDirectUpload(upload.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if upload.CompletedAt == nil {
+				t.Errorf("completed upload has no completed_at: %+v", upload)
+			}
+		})
+	}
+}
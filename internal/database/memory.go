@@ -0,0 +1,250 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore keeps users, videos and refresh tokens in maps. It behaves
+// like a Client down to the second-precision timestamps, which the
+// conformance tests check. The server always runs on a Client; handler tests
+// that need nothing beyond these stores run on a MemoryStore.
+type MemoryStore struct {
+	mu            sync.Mutex
+	users         map[uuid.UUID]User
+	userEmails    map[string]uuid.UUID
+	videos        map[uuid.UUID]Video
+	refreshTokens map[string]RefreshToken
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:         map[uuid.UUID]User{},
+		userEmails:    map[string]uuid.UUID{},
+		videos:        map[uuid.UUID]Video{},
+		refreshTokens: map[string]RefreshToken{},
+	}
+}
+
+// now is CURRENT_TIMESTAMP: UTC, whole seconds.
+func (s *MemoryStore) now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+func (s *MemoryStore) CreateUser(params CreateUserParams) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.userEmails[params.Email]; ok {
+		return nil, fmt.Errorf("%w: email %s is already registered", ErrConflict, params.Email)
+	}
+	now := s.now()
+	user := User{
+		ID:               uuid.New(),
+		CreatedAt:        now,
+		UpdatedAt:        now,
+		CreateUserParams: params,
+	}
+	s.users[user.ID] = user
+	s.userEmails[user.Email] = user.ID
+	return &user, nil
+}
+
+func (s *MemoryStore) GetUser(id uuid.UUID) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *MemoryStore) GetUserByEmail(email string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.userEmails[email]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return s.users[id], nil
+}
+
+func (s *MemoryStore) GetUserByRefreshToken(token string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt, ok := s.refreshTokens[token]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	user, ok := s.users[rt.UserID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+// GetUsers returns only IDs and emails, like Client.GetUsers.
+func (s *MemoryStore) GetUsers() ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := []User{}
+	for _, user := range s.users {
+		users = append(users, User{ID: user.ID, CreateUserParams: CreateUserParams{Email: user.Email}})
+	}
+	return users, nil
+}
+
+func (s *MemoryStore) DeleteUser(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.userEmails, user.Email)
+	delete(s.users, id)
+	return nil
+}
+
+func (s *MemoryStore) CreateVideo(params CreateVideoParams) (Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	video := Video{
+		ID:                uuid.New(),
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		CreateVideoParams: params,
+	}
+	s.videos[video.ID] = video
+	return video, nil
+}
+
+func (s *MemoryStore) GetVideo(id uuid.UUID) (Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	video, ok := s.videos[id]
+	if !ok {
+		return Video{}, ErrNotFound
+	}
+	return video, nil
+}
+
+func (s *MemoryStore) GetVideos(userID uuid.UUID, q VideoQuery) (VideoPage, error) {
+	cursor, err := q.cursor()
+	if err != nil {
+		return VideoPage{}, err
+	}
+	if _, ok := q.sort().column(); !ok {
+		return VideoPage{}, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+	// inOrder compares a and b in the order the page is listed in.
+	inOrder := func(a, b Video) int {
+		c := compareVideos(a, b, q.sort())
+		if !q.Ascending {
+			c = -c
+		}
+		return c
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	videos := []Video{}
+	for _, video := range s.videos {
+		if video.UserID != userID || !q.Filter.matches(video) {
+			continue
+		}
+		if cursor != nil && inOrder(video, cursor.video()) <= 0 {
+			continue
+		}
+		videos = append(videos, video)
+	}
+	slices.SortFunc(videos, inOrder)
+	if q.Limit > 0 && len(videos) > q.Limit+1 {
+		videos = videos[:q.Limit+1]
+	}
+	return q.page(videos), nil
+}
+
+func (s *MemoryStore) GetAllVideos() ([]Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	videos := []Video{}
+	for _, video := range s.videos {
+		videos = append(videos, video)
+	}
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].CreatedAt.Before(videos[j].CreatedAt)
+	})
+	return videos, nil
+}
+
+// UpdateVideo leaves the creation time and metadata alone, like
+// Client.UpdateVideo.
+func (s *MemoryStore) UpdateVideo(video Video) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.videos[video.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	video.CreatedAt = stored.CreatedAt
+	video.UpdatedAt = s.now()
+	video.Metadata = stored.Metadata
+	s.videos[video.ID] = video
+	return nil
+}
+
+func (s *MemoryStore) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.refreshTokens[params.Token]; ok {
+		return RefreshToken{}, fmt.Errorf("%w: refresh token already exists", ErrConflict)
+	}
+	now := s.now()
+	rt := RefreshToken{
+		CreateRefreshTokenParams: params,
+		CreatedAt:                now,
+		UpdatedAt:                now,
+	}
+	s.refreshTokens[rt.Token] = rt
+	return rt, nil
+}
+
+func (s *MemoryStore) GetRefreshToken(token string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt, ok := s.refreshTokens[token]
+	if !ok {
+		return RefreshToken{}, ErrNotFound
+	}
+	return rt, nil
+}
+
+func (s *MemoryStore) RevokeRefreshToken(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt, ok := s.refreshTokens[token]
+	if !ok {
+		return ErrNotFound
+	}
+	now := s.now()
+	rt.RevokedAt = &now
+	s.refreshTokens[token] = rt
+	return nil
+}
+
+func (s *MemoryStore) DeleteRefreshToken(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.refreshTokens[token]; !ok {
+		return ErrNotFound
+	}
+	delete(s.refreshTokens, token)
+	return nil
+}
@@ -0,0 +1,404 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew is returned when the database has migrations applied that
+// this build doesn't know about, i.e. it was migrated by a newer release.
+var ErrSchemaTooNew = errors.New("database schema is newer than this build")
+
+// Migration is one numbered schema change, read from
+// migrations/NNNN_name.up.sql and its matching .down.sql. Where an engine
+// needs different SQL, NNNN_name.up.postgres.sql takes the place of the
+// shared file, so both engines go through the same versions.
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// MigrationStatus is a known migration and when it was applied, nil if it
+// is pending.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)(?:\.(postgres))?\.sql$`)
+
+func loadMigrations(d dialect) ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	overrides := map[string]string{}
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		data, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		version, _ := strconv.Atoi(match[1])
+		if match[4] != "" {
+			if dialect(match[4]) == d {
+				overrides[fmt.Sprintf("%04d_%s.%s", version, match[2], match[3])] = string(data)
+			}
+			continue
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.up = string(data)
+		} else {
+			m.down = string(data)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		prefix := fmt.Sprintf("%04d_%s.", m.Version, m.Name)
+		if script, ok := overrides[prefix+"up"]; ok {
+			m.up = script
+			delete(overrides, prefix+"up")
+		}
+		if script, ok := overrides[prefix+"down"]; ok {
+			m.down = script
+			delete(overrides, prefix+"down")
+		}
+		migrations = append(migrations, *m)
+	}
+	for name := range overrides {
+		return nil, fmt.Errorf("%s migration %s has no shared counterpart", d, name)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %04d_%s is out of sequence", m.Version, m.Name)
+		}
+	}
+	return migrations, nil
+}
+
+// LatestSchemaVersion is the version the newest migration in this build
+// brings the schema to.
+func LatestSchemaVersion() int {
+	migrations, err := loadMigrations(dialectSQLite)
+	if err != nil || len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// ensureMigrationsTable creates schema_migrations on first use. A database
+// created by the old autoMigrate is checked against the baseline first, and
+// only marked as migrated once that has worked, so a failed adoption is
+// retried.
+func (c Client) ensureMigrationsTable() error {
+	exists, err := c.tableExists("schema_migrations")
+	if err != nil || exists {
+		return err
+	}
+	// Only SQLite databases predate migrations.
+	legacy := false
+	if c.db.dialect == dialectSQLite {
+		legacy, err = c.tableExists("users")
+		if err != nil {
+			return err
+		}
+	}
+	if legacy {
+		err = c.adoptLegacySchema()
+		if err != nil {
+			return fmt.Errorf("couldn't adopt existing schema: %w", err)
+		}
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`)
+	if err != nil {
+		return err
+	}
+	if legacy {
+		migrations, err := loadMigrations(c.db.dialect)
+		if err != nil {
+			return err
+		}
+		baseline := migrations[0]
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", baseline.Version, baseline.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// legacySchema is every table the old autoMigrate created and its columns.
+var legacySchema = map[string][]string{
+	"users":          {"id", "created_at", "updated_at", "password", "email"},
+	"refresh_tokens": {"token", "created_at", "updated_at", "revoked_at", "user_id", "expires_at"},
+	"videos":         {"id", "created_at", "updated_at", "title", "description", "thumbnail_url", "video_url", "user_id"},
+}
+
+// adoptLegacySchema checks that a database created by the old autoMigrate
+// has exactly the schema the baseline migration creates, so it can be
+// recorded at that version. Anything else, like a database an unreleased
+// build added tables or columns to, is refused rather than guessed at.
+func (c Client) adoptLegacySchema() error {
+	rows, err := c.db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	tables := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		tables = append(tables, name)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(tables) != len(legacySchema) {
+		return fmt.Errorf("found tables %v, want %d", tables, len(legacySchema))
+	}
+	for _, table := range tables {
+		want, ok := legacySchema[table]
+		if !ok {
+			return fmt.Errorf("unexpected table %s", table)
+		}
+		columns, err := c.columns(table)
+		if err != nil {
+			return err
+		}
+		if strings.Join(columns, ",") != strings.Join(want, ",") {
+			return fmt.Errorf("table %s has columns %v, want %v", table, columns, want)
+		}
+	}
+	return nil
+}
+
+func (c Client) columns(table string) ([]string, error) {
+	rows, err := c.db.Query("SELECT * FROM " + table + " LIMIT 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
+
+func (c Client) tableExists(name string) (bool, error) {
+	query := "SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?"
+	if c.db.dialect == dialectPostgres {
+		query = "SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?"
+	}
+	var found string
+	err := c.db.QueryRow(query, name).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SchemaVersion returns the version of the newest applied migration, 0 for
+// an empty database.
+func (c Client) SchemaVersion() (int, error) {
+	if err := c.ensureMigrationsTable(); err != nil {
+		return 0, err
+	}
+	var version int
+	err := c.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+// MigrationStatus lists every migration this build knows about.
+func (c Client) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(c.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	rows, err := c.db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := []MigrationStatus{}
+	for _, m := range migrations {
+		status := MigrationStatus{Migration: m}
+		if appliedAt, ok := applied[m.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies every pending migration in order, each in its own
+// transaction, and returns the ones it applied. It returns ErrSchemaTooNew
+// rather than touching a database migrated by a newer build.
+func (c Client) MigrateUp() ([]Migration, error) {
+	migrations, err := loadMigrations(c.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	version, err := c.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if version > len(migrations) {
+		return nil, fmt.Errorf("%w: database is at version %d, this build knows up to %d", ErrSchemaTooNew, version, len(migrations))
+	}
+
+	applied := []Migration{}
+	for _, m := range migrations[version:] {
+		ok, err := c.runMigration(m, true)
+		if err != nil {
+			return applied, fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		if ok {
+			applied = append(applied, m)
+		}
+	}
+	return applied, nil
+}
+
+// MigrateDown reverts the newest applied migration and returns it. ok is
+// false when there is nothing to revert.
+func (c Client) MigrateDown() (m Migration, ok bool, err error) {
+	migrations, err := loadMigrations(c.db.dialect)
+	if err != nil {
+		return Migration{}, false, err
+	}
+	version, err := c.SchemaVersion()
+	if err != nil {
+		return Migration{}, false, err
+	}
+	if version == 0 {
+		return Migration{}, false, nil
+	}
+	if version > len(migrations) {
+		return Migration{}, false, fmt.Errorf("%w: migration %d isn't known to this build", ErrSchemaTooNew, version)
+	}
+
+	m = migrations[version-1]
+	ok, err = c.runMigration(m, false)
+	if err != nil {
+		return Migration{}, false, fmt.Errorf("reverting migration %04d_%s failed: %w", m.Version, m.Name, err)
+	}
+	return m, ok, nil
+}
+
+// runMigration applies or, if up is false, reverts m together with its
+// schema_migrations row in one transaction, so a migration that fails
+// halfway leaves nothing behind. Replicas starting together all try to
+// migrate; on Postgres the table lock makes the others wait and then find
+// the work done, which is reported as ok false.
+func (c Client) runMigration(m Migration, up bool) (ok bool, err error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if c.db.dialect == dialectPostgres {
+		_, err = tx.Exec("LOCK TABLE schema_migrations IN EXCLUSIVE MODE")
+		if err != nil {
+			return false, err
+		}
+	}
+	var count int
+	err = tx.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE version = ?", m.Version).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	if (count > 0) == up {
+		return false, nil
+	}
+
+	script := m.up
+	record := "INSERT INTO schema_migrations (version, name) VALUES (?, ?)"
+	args := []any{m.Version, m.Name}
+	if !up {
+		script = m.down
+		record = "DELETE FROM schema_migrations WHERE version = ?"
+		args = []any{m.Version}
+	}
+	if hasStatements(script) {
+		_, err = tx.Exec(script)
+		if err != nil {
+			return false, err
+		}
+	}
+	_, err = tx.Exec(record, args...)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// hasStatements reports whether script is more than comments. An engine
+// override can be empty when the shared change doesn't apply to it.
+func hasStatements(script string) bool {
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+-- The baseline schema for Postgres. videos is created with the column types
+-- 0002 fixes on SQLite.
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMPTZ,
+	user_id TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS videos (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT,
+	user_id TEXT,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
@@ -0,0 +1,32 @@
+-- The schema as the original autoMigrate created it, column types and all.
+-- Databases created back then are adopted at this version; see
+-- adoptLegacySchema.
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMP,
+	user_id TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS videos (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT TEXT,
+	user_id INTEGER,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
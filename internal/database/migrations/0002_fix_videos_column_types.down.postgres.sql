@@ -0,0 +1,2 @@
+-- Postgres databases start out with the fixed column types; see
+-- 0001_baseline.up.postgres.sql.
@@ -0,0 +1,15 @@
+DROP TABLE IF EXISTS video_versions;
+DROP TABLE IF EXISTS cleanup_tasks;
+DROP TABLE IF EXISTS video_blob_sources;
+DROP TABLE IF EXISTS video_blobs;
+DROP TABLE IF EXISTS video_metadata;
+DROP TABLE IF EXISTS processing_jobs;
+DROP INDEX IF EXISTS idx_videos_content_hash;
+ALTER TABLE videos DROP COLUMN thumbnail_keys;
+ALTER TABLE videos DROP COLUMN video_key;
+ALTER TABLE videos DROP COLUMN content_hash;
+ALTER TABLE videos DROP COLUMN preview_sprites;
+ALTER TABLE videos DROP COLUMN thumbnails;
+ALTER TABLE videos DROP COLUMN thumbnail_generated;
+ALTER TABLE videos DROP COLUMN dash_url;
+ALTER TABLE videos DROP COLUMN hls_url;
@@ -0,0 +1,93 @@
+-- Everything processing, deduplicated storage, cleanup and versioning added
+-- to the schema before migrations existed. Sizes, bit rates and durations
+-- get types wide enough for them.
+ALTER TABLE videos ADD COLUMN hls_url TEXT;
+ALTER TABLE videos ADD COLUMN dash_url TEXT;
+ALTER TABLE videos ADD COLUMN thumbnail_generated BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE videos ADD COLUMN thumbnails TEXT;
+ALTER TABLE videos ADD COLUMN preview_sprites TEXT;
+ALTER TABLE videos ADD COLUMN content_hash TEXT;
+ALTER TABLE videos ADD COLUMN video_key TEXT;
+ALTER TABLE videos ADD COLUMN thumbnail_keys TEXT;
+CREATE INDEX IF NOT EXISTS idx_videos_content_hash ON videos(content_hash);
+
+CREATE TABLE IF NOT EXISTS processing_jobs (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	state TEXT NOT NULL,
+	source_path TEXT NOT NULL,
+	error TEXT,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
+CREATE INDEX IF NOT EXISTS idx_processing_jobs_state ON processing_jobs(state, created_at);
+CREATE INDEX IF NOT EXISTS idx_processing_jobs_video_id ON processing_jobs(video_id);
+
+CREATE TABLE IF NOT EXISTS video_metadata (
+	video_id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	duration DOUBLE PRECISION NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	video_codec TEXT NOT NULL,
+	audio_codec TEXT NOT NULL,
+	frame_rate DOUBLE PRECISION NOT NULL,
+	bit_rate BIGINT NOT NULL,
+	audio_channels INTEGER NOT NULL,
+	audio_sample_rate INTEGER NOT NULL,
+	rotation INTEGER NOT NULL,
+	container TEXT NOT NULL,
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
+
+CREATE TABLE IF NOT EXISTS video_blobs (
+	hash TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	storage_key TEXT NOT NULL,
+	size BIGINT NOT NULL,
+	hls_url TEXT,
+	dash_url TEXT,
+	preview_sprites TEXT,
+	ref_count INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS video_blob_sources (
+	source_hash TEXT PRIMARY KEY,
+	hash TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(hash) REFERENCES video_blobs(hash)
+);
+
+CREATE TABLE IF NOT EXISTS cleanup_tasks (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	store TEXT NOT NULL,
+	key TEXT NOT NULL,
+	prefix BOOLEAN NOT NULL DEFAULT FALSE,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	next_attempt_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_cleanup_tasks_next_attempt_at ON cleanup_tasks(next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS video_versions (
+	id TEXT PRIMARY KEY,
+	video_id TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMPTZ,
+	uploaded_by TEXT,
+	video_url TEXT,
+	hls_url TEXT,
+	dash_url TEXT,
+	preview_sprites TEXT,
+	content_hash TEXT,
+	video_key TEXT,
+	metadata TEXT,
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
+CREATE INDEX IF NOT EXISTS idx_video_versions_video_id ON video_versions(video_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_video_versions_expires_at ON video_versions(expires_at);
@@ -0,0 +1,4 @@
+-- A worker holds a job until locked_until and keeps pushing it forward while
+-- it runs. Recovery only takes over jobs whose lease has run out, so replicas
+-- sharing the database leave each other's running jobs alone.
+ALTER TABLE processing_jobs ADD COLUMN locked_until TIMESTAMP;
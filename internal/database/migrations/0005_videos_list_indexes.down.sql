@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_videos_user_title;
+DROP INDEX IF EXISTS idx_videos_user_updated;
+DROP INDEX IF EXISTS idx_videos_user_created;
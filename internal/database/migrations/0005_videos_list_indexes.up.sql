@@ -0,0 +1,5 @@
+-- GetVideos pages through a user's videos by each sort it offers, so each
+-- gets an index that serves both the filter on user_id and the order.
+CREATE INDEX IF NOT EXISTS idx_videos_user_created ON videos(user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_videos_user_updated ON videos(user_id, updated_at, id);
+CREATE INDEX IF NOT EXISTS idx_videos_user_title ON videos(user_id, title, id);
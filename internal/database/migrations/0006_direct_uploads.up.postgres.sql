@@ -0,0 +1,11 @@
+-- Every presigned upload gets a row, and its upload token names it. The row
+-- is marked completed when the upload is queued for processing, so a token
+-- can only complete one upload.
+CREATE TABLE IF NOT EXISTS direct_uploads (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	completed_at TIMESTAMPTZ,
+	video_id TEXT NOT NULL,
+	key TEXT NOT NULL,
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
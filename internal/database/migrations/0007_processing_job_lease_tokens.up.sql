@@ -0,0 +1,4 @@
+-- Each claim gives the job a new lease token, and a worker's updates only
+-- apply while the job still carries its token. A worker whose lease ran out
+-- and was taken over can't overwrite the new owner's progress.
+ALTER TABLE processing_jobs ADD COLUMN lease TEXT;
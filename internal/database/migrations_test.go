@@ -0,0 +1,52 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// newLegacyClient returns a SQLite Client whose schema was created the way
+// the old autoMigrate did, plus extra statements.
+func newLegacyClient(t *testing.T, extra ...string) Client {
+	t.Helper()
+	c, err := NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.db.Close() })
+	migrations, err := loadMigrations(dialectSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range append([]string{migrations[0].up}, extra...) {
+		if _, err := c.db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func TestMigrateUpAdoptsLegacySchema(t *testing.T) {
+	c := newLegacyClient(t, `INSERT INTO users (id, password, email) VALUES ('5b0c4a7e-2f1d-4c3e-9a8b-6d7e8f901234', 'hash', 'a@example.com')`)
+	applied, err := c.MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != LatestSchemaVersion()-1 || applied[0].Version != 2 {
+		t.Errorf("applied %d migrations starting at %d, want all but the baseline", len(applied), applied[0].Version)
+	}
+	if _, err := c.GetUserByEmail("a@example.com"); err != nil {
+		t.Errorf("legacy user is gone: %v", err)
+	}
+}
+
+func TestMigrateUpRefusesUnknownLegacySchema(t *testing.T) {
+	c := newLegacyClient(t, `ALTER TABLE videos ADD COLUMN hls_url TEXT`)
+	if _, err := c.MigrateUp(); err == nil {
+		t.Fatal("migrated a schema that isn't the baseline")
+	}
+	version, err := c.SchemaVersion()
+	if err == nil || version != 0 {
+		t.Errorf("SchemaVersion returned %d, %v after a refused adoption", version, err)
+	}
+}
@@ -0,0 +1,39 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// PreviewSprites describes the seek-preview track of a video: sprite sheets
+// of frames sampled every Interval seconds, laid out Columns x Rows per
+// sheet, and a WebVTT file mapping time ranges to tiles with #xywh= URLs.
+type PreviewSprites struct {
+	VTTURL     string   `json:"vtt_url"`
+	SheetURLs  []string `json:"sheet_urls"`
+	Interval   float64  `json:"interval"`
+	Columns    int      `json:"columns"`
+	Rows       int      `json:"rows"`
+	TileWidth  int      `json:"tile_width"`
+	TileHeight int      `json:"tile_height"`
+}
+
+func (p PreviewSprites) Value() (driver.Value, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (p *PreviewSprites) Scan(src any) error {
+	switch src := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(src), p)
+	case []byte:
+		return json.Unmarshal(src, p)
+	default:
+		return fmt.Errorf("can't scan %T into PreviewSprites", src)
+	}
+}
@@ -0,0 +1,261 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ProcessingState string
+
+const (
+	ProcessingStateQueued      ProcessingState = "queued"
+	ProcessingStateProbing     ProcessingState = "probing"
+	ProcessingStateTranscoding ProcessingState = "transcoding"
+	ProcessingStateUploading   ProcessingState = "uploading"
+	ProcessingStateReady       ProcessingState = "ready"
+	ProcessingStateFailed      ProcessingState = "failed"
+)
+
+func (s ProcessingState) Finished() bool {
+	return s == ProcessingStateReady || s == ProcessingStateFailed
+}
+
+type ProcessingJob struct {
+	ID         uuid.UUID       `json:"id"`
+	VideoID    uuid.UUID       `json:"video_id"`
+	State      ProcessingState `json:"state"`
+	SourcePath string          `json:"-"`
+	Error      *string         `json:"error"`
+	Attempts   int             `json:"attempts"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	// Lease is set on a job ClaimProcessingJob returned. Updates to the job
+	// only apply while it still holds this lease.
+	Lease uuid.UUID `json:"-"`
+}
+
+const processingJobColumns = `
+	id,
+	video_id,
+	state,
+	source_path,
+	error,
+	attempts,
+	created_at,
+	updated_at
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanProcessingJob(row rowScanner) (ProcessingJob, error) {
+	var job ProcessingJob
+	err := row.Scan(
+		&job.ID,
+		&job.VideoID,
+		&job.State,
+		&job.SourcePath,
+		&job.Error,
+		&job.Attempts,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+	return job, err
+}
+
+func (c Client) CreateProcessingJob(videoID uuid.UUID, sourcePath string) (ProcessingJob, error) {
+	id, err := insertProcessingJob(c.db, c.db.dialect, videoID, sourcePath)
+	if err != nil {
+		return ProcessingJob{}, err
+	}
+	return c.GetProcessingJob(id)
+}
+
+// insertProcessingJob queues a job. Workers claim jobs in created_at order,
+// so it is stored with more than CURRENT_TIMESTAMP's one-second precision.
+func insertProcessingJob(db execer, d dialect, videoID uuid.UUID, sourcePath string) (uuid.UUID, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO processing_jobs (
+		id,
+		video_id,
+		state,
+		source_path,
+		attempts,
+		created_at,
+		updated_at
+	) VALUES (?, ?, ?, ?, 0, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := db.Exec(query, id, videoID, ProcessingStateQueued, sourcePath, d.timeArg(time.Now()))
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return id, nil
+}
+
+func (c Client) GetProcessingJob(id uuid.UUID) (ProcessingJob, error) {
+	query := `SELECT ` + processingJobColumns + `
+	FROM processing_jobs
+	WHERE id = ?
+	`
+	job, err := scanProcessingJob(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ProcessingJob{}, ErrNotFound
+		}
+		return ProcessingJob{}, err
+	}
+	return job, nil
+}
+
+// GetLatestProcessingJob returns the most recently created job for a video,
+// or ErrNotFound if the video was never uploaded.
+func (c Client) GetLatestProcessingJob(videoID uuid.UUID) (ProcessingJob, error) {
+	query := `SELECT ` + processingJobColumns + `
+	FROM processing_jobs
+	WHERE video_id = ?
+	ORDER BY created_at DESC, id DESC
+	LIMIT 1
+	`
+	job, err := scanProcessingJob(c.db.QueryRow(query, videoID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ProcessingJob{}, ErrNotFound
+		}
+		return ProcessingJob{}, err
+	}
+	return job, nil
+}
+
+// ClaimProcessingJob moves the oldest queued job to the probing state and
+// returns it, leased to the caller until lockedUntil under a new job.Lease.
+// The select and update happen in one statement so concurrent workers never
+// claim the same job. ok is false when nothing is queued.
+func (c Client) ClaimProcessingJob(lockedUntil time.Time) (job ProcessingJob, ok bool, err error) {
+	lease := uuid.New()
+	query := `
+	UPDATE processing_jobs
+	SET
+		state = ?,
+		attempts = attempts + 1,
+		lease = ?,
+		locked_until = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = (
+		SELECT id FROM processing_jobs
+		WHERE state = ?
+		ORDER BY created_at, id
+		LIMIT 1
+		` + c.db.dialect.skipLocked() + `
+	)
+	RETURNING ` + processingJobColumns
+	job, err = scanProcessingJob(c.db.QueryRow(query, ProcessingStateProbing, lease, c.db.dialect.timeArg(lockedUntil), ProcessingStateQueued))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ProcessingJob{}, false, nil
+		}
+		return ProcessingJob{}, false, err
+	}
+	job.Lease = lease
+	return job, true, nil
+}
+
+// ExtendProcessingJobLease moves the lease on a running job forward to
+// lockedUntil. It returns ErrNotFound if the job isn't running any more or
+// no longer holds lease.
+func (c Client) ExtendProcessingJobLease(id, lease uuid.UUID, lockedUntil time.Time) error {
+	query := `
+	UPDATE processing_jobs
+	SET locked_until = ?
+	WHERE id = ? AND lease = ? AND state NOT IN (?, ?, ?)
+	`
+	return expectRows(c.db.Exec(
+		query,
+		c.db.dialect.timeArg(lockedUntil),
+		id,
+		lease,
+		ProcessingStateQueued,
+		ProcessingStateReady,
+		ProcessingStateFailed,
+	))
+}
+
+// UpdateProcessingJobState records the progress of a claimed job. It
+// returns ErrNotFound if the job no longer holds lease.
+func (c Client) UpdateProcessingJobState(id, lease uuid.UUID, state ProcessingState, errMsg *string) error {
+	query := `
+	UPDATE processing_jobs
+	SET
+		state = ?,
+		error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND lease = ?
+	`
+	return expectRows(c.db.Exec(query, state, errMsg, id, lease))
+}
+
+// GetAbandonedProcessingJobs returns, oldest first, every running job whose
+// worker stopped extending its lease before now. Jobs left running by a
+// version without leases have none and count as well. Queued jobs aren't
+// abandoned; they wait for ClaimProcessingJob.
+func (c Client) GetAbandonedProcessingJobs(now time.Time) ([]ProcessingJob, error) {
+	query := `SELECT ` + processingJobColumns + `
+	FROM processing_jobs
+	WHERE state NOT IN (?, ?, ?)
+	AND (locked_until IS NULL OR locked_until < ?)
+	ORDER BY created_at, id
+	`
+	rows, err := c.db.Query(
+		query,
+		ProcessingStateQueued,
+		ProcessingStateReady,
+		ProcessingStateFailed,
+		c.db.dialect.timeArg(now),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []ProcessingJob{}
+	for rows.Next() {
+		job, err := scanProcessingJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// RecoverProcessingJob moves a job GetAbandonedProcessingJobs returned to
+// state, queued to run again or failed, and revokes its lease. It returns
+// ErrNotFound if a worker has claimed the job, extended its lease, queued it
+// again or finished it since.
+func (c Client) RecoverProcessingJob(id uuid.UUID, state ProcessingState, errMsg *string, now time.Time) error {
+	query := `
+	UPDATE processing_jobs
+	SET
+		state = ?,
+		error = ?,
+		lease = NULL,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	AND state NOT IN (?, ?, ?)
+	AND (locked_until IS NULL OR locked_until < ?)
+	`
+	return expectRows(c.db.Exec(
+		query,
+		state,
+		errMsg,
+		id,
+		ProcessingStateQueued,
+		ProcessingStateReady,
+		ProcessingStateFailed,
+		c.db.dialect.timeArg(now),
+	))
+}
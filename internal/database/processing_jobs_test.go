@@ -0,0 +1,153 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newTestProcessingJob(t *testing.T, c Client) ProcessingJob {
+	t.Helper()
+	user, err := c.CreateUser(CreateUserParams{Email: t.Name() + "@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := c.CreateVideo(CreateVideoParams{Title: "Test", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := c.CreateProcessingJob(video.ID, "/spool/upload.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestProcessingJobLeases(t *testing.T) {
+	for name, c := range newTestClients(t) {
+		t.Run(name, func(t *testing.T) {
+			testProcessingJobLeases(t, c)
+		})
+	}
+}
+
+// Jobs queued within the same second are still claimed first in, first
+// out.
+func TestClaimProcessingJobOrder(t *testing.T) {
+	for name, c := range newTestClients(t) {
+		t.Run(name, func(t *testing.T) {
+			first := newTestProcessingJob(t, c)
+			queued := []uuid.UUID{first.ID}
+			for range 4 {
+				job, err := c.CreateProcessingJob(first.VideoID, "/spool/upload.mp4")
+				if err != nil {
+					t.Fatal(err)
+				}
+				queued = append(queued, job.ID)
+			}
+			for i, want := range queued {
+				job, ok, err := c.ClaimProcessingJob(time.Now().UTC().Add(time.Minute))
+				if err != nil || !ok {
+					t.Fatalf("claim %d: ok %v, err %v", i, ok, err)
+				}
+				if job.ID != want {
+					t.Errorf("claim %d got %v, want %v", i, job.ID, want)
+				}
+			}
+		})
+	}
+}
+
+func testProcessingJobLeases(t *testing.T, c Client) {
+	const lease = 2 * time.Minute
+	now := time.Now().UTC()
+	job := newTestProcessingJob(t, c)
+
+	if err := c.ExtendProcessingJobLease(job.ID, uuid.New(), now.Add(lease)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("extending the lease on a queued job returned %v, want ErrNotFound", err)
+	}
+	abandoned, err := c.GetAbandonedProcessingJobs(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(abandoned) != 0 {
+		t.Errorf("a queued job counts as abandoned: %+v", abandoned)
+	}
+	err = c.RecoverProcessingJob(job.ID, ProcessingStateFailed, nil, now)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("recovering a queued job returned %v, want ErrNotFound", err)
+	}
+	claimed, ok, err := c.ClaimProcessingJob(now.Add(lease))
+	if err != nil || !ok {
+		t.Fatalf("couldn't claim job: ok %v, err %v", ok, err)
+	}
+	if claimed.ID != job.ID || claimed.State != ProcessingStateProbing || claimed.Attempts != 1 {
+		t.Errorf("claimed %+v", claimed)
+	}
+	if _, ok, _ := c.ClaimProcessingJob(now.Add(lease)); ok {
+		t.Error("claimed a running job a second time")
+	}
+
+	abandoned, err = c.GetAbandonedProcessingJobs(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(abandoned) != 0 {
+		t.Errorf("a leased job counts as abandoned: %+v", abandoned)
+	}
+	err = c.RecoverProcessingJob(job.ID, ProcessingStateQueued, nil, now)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("recovering a leased job returned %v, want ErrNotFound", err)
+	}
+
+	// The worker keeps its lease going, then stops.
+	if err := c.ExtendProcessingJobLease(job.ID, claimed.Lease, now.Add(2*lease)); err != nil {
+		t.Fatal(err)
+	}
+	later := now.Add(2*lease + time.Minute)
+	abandoned, err = c.GetAbandonedProcessingJobs(now.Add(lease + time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(abandoned) != 0 {
+		t.Errorf("job counts as abandoned before its extended lease ran out: %+v", abandoned)
+	}
+	abandoned, err = c.GetAbandonedProcessingJobs(later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(abandoned) != 1 || abandoned[0].ID != job.ID {
+		t.Fatalf("abandoned jobs %+v, want just %v", abandoned, job.ID)
+	}
+
+	if err := c.RecoverProcessingJob(job.ID, ProcessingStateQueued, nil, later); err != nil {
+		t.Fatal(err)
+	}
+	stale := claimed
+	claimed, ok, err = c.ClaimProcessingJob(later.Add(lease))
+	if err != nil || !ok {
+		t.Fatalf("couldn't claim the recovered job: ok %v, err %v", ok, err)
+	}
+	if claimed.ID != job.ID || claimed.Attempts != 2 || claimed.Lease == stale.Lease {
+		t.Errorf("claimed %+v, want attempt 2 of %v under a new lease", claimed, job.ID)
+	}
+
+	// The first worker comes back; its lease is gone.
+	if err := c.ExtendProcessingJobLease(job.ID, stale.Lease, later.Add(2*lease)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("extending a lost lease returned %v, want ErrNotFound", err)
+	}
+	err = c.UpdateProcessingJobState(job.ID, stale.Lease, ProcessingStateFailed, nil)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("updating under a lost lease returned %v, want ErrNotFound", err)
+	}
+
+	if err := c.UpdateProcessingJobState(job.ID, claimed.Lease, ProcessingStateReady, nil); err != nil {
+		t.Fatal(err)
+	}
+	err = c.RecoverProcessingJob(job.ID, ProcessingStateFailed, nil, later.Add(2*lease))
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("recovering a finished job returned %v, want ErrNotFound", err)
+	}
+}
@@ -2,6 +2,8 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -32,6 +34,9 @@ func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToke
 	`
 	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return RefreshToken{}, fmt.Errorf("%w: refresh token already exists", ErrConflict)
+		}
 		return RefreshToken{}, err
 	}
 
@@ -44,8 +49,7 @@ func (c Client) RevokeRefreshToken(token string) error {
 		SET revoked_at = CURRENT_TIMESTAMP
 		WHERE token = ?
 	`
-	_, err := c.db.Exec(query, token)
-	return err
+	return expectRows(c.db.Exec(query, token))
 }
 
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
@@ -59,8 +63,8 @@ func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	err := c.db.QueryRow(query, token).
 		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return RefreshToken{}, nil
+		if errors.Is(err, sql.ErrNoRows) {
+			return RefreshToken{}, ErrNotFound
 		}
 		return RefreshToken{}, err
 	}
@@ -78,6 +82,5 @@ func (c Client) DeleteRefreshToken(token string) error {
 		DELETE FROM refresh_tokens
 		WHERE token = ?
 	`
-	_, err := c.db.Exec(query, token)
-	return err
+	return expectRows(c.db.Exec(query, token))
 }
@@ -0,0 +1,178 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// store is what a Client and a MemoryStore both implement.
+type store interface {
+	UserStore
+	VideoStore
+	RefreshTokenStore
+}
+
+// TestStoreConformance runs the same checks against every implementation,
+// so handlers see the same results and errors from each.
+func TestStoreConformance(t *testing.T) {
+	stores := map[string]store{"memory": NewMemoryStore()}
+	for name, c := range newTestClients(t) {
+		stores[name] = c
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			t.Run("users", func(t *testing.T) { testUserStore(t, s) })
+			t.Run("videos", func(t *testing.T) { testVideoStore(t, s) })
+			t.Run("refresh tokens", func(t *testing.T) { testRefreshTokenStore(t, s) })
+		})
+	}
+}
+
+func wantNotFound(t *testing.T, what string, err error) {
+	t.Helper()
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("%s returned %v, want ErrNotFound", what, err)
+	}
+}
+
+func testUserStore(t *testing.T, s store) {
+	params := CreateUserParams{Email: "conformance-users@example.com", Password: "hash"}
+	user, err := s.CreateUser(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateUser(params); !errors.Is(err, ErrConflict) {
+		t.Errorf("second user with the same email returned %v, want ErrConflict", err)
+	}
+
+	got, err := s.GetUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != params.Email || got.Password != params.Password {
+		t.Errorf("GetUser returned %+v", got)
+	}
+	byEmail, err := s.GetUserByEmail(params.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byEmail.ID != user.ID {
+		t.Errorf("GetUserByEmail returned %v, want %v", byEmail.ID, user.ID)
+	}
+
+	_, err = s.GetUser(uuid.New())
+	wantNotFound(t, "GetUser of an unknown ID", err)
+	_, err = s.GetUserByEmail("nobody@example.com")
+	wantNotFound(t, "GetUserByEmail of an unknown email", err)
+	_, err = s.GetUserByRefreshToken("no-such-token")
+	wantNotFound(t, "GetUserByRefreshToken of an unknown token", err)
+	wantNotFound(t, "DeleteUser of an unknown ID", s.DeleteUser(uuid.New()))
+
+	if err := s.DeleteUser(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetUser(user.ID)
+	wantNotFound(t, "GetUser of a deleted user", err)
+	wantNotFound(t, "a second DeleteUser", s.DeleteUser(user.ID))
+	if _, err := s.CreateUser(params); err != nil {
+		t.Errorf("couldn't reuse a deleted user's email: %v", err)
+	}
+}
+
+func testVideoStore(t *testing.T, s store) {
+	user, err := s.CreateUser(CreateUserParams{Email: "conformance-videos@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := s.CreateVideo(CreateVideoParams{Title: "Boots", Description: "A bear", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	video.Title = "Boots the bear"
+	if err := s.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Boots the bear" || got.UserID != user.ID {
+		t.Errorf("GetVideo returned %+v", got)
+	}
+	if !got.CreatedAt.Equal(video.CreatedAt) {
+		t.Errorf("UpdateVideo changed created_at from %v to %v", video.CreatedAt, got.CreatedAt)
+	}
+
+	_, err = s.GetVideo(uuid.New())
+	wantNotFound(t, "GetVideo of an unknown ID", err)
+	missing := video
+	missing.ID = uuid.New()
+	wantNotFound(t, "UpdateVideo of an unknown video", s.UpdateVideo(missing))
+
+	page, err := s.GetVideos(user.ID, VideoQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Videos) != 1 || page.Videos[0].ID != video.ID {
+		t.Errorf("GetVideos returned %+v", page.Videos)
+	}
+	page, err = s.GetVideos(uuid.New(), VideoQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Videos) != 0 {
+		t.Errorf("GetVideos for another user returned %+v", page.Videos)
+	}
+}
+
+func testRefreshTokenStore(t *testing.T, s store) {
+	user, err := s.CreateUser(CreateUserParams{Email: "conformance-tokens@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := CreateRefreshTokenParams{
+		Token:     "conformance-token",
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(time.Hour).Truncate(time.Second),
+	}
+	if _, err := s.CreateRefreshToken(params); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateRefreshToken(params); !errors.Is(err, ErrConflict) {
+		t.Errorf("second refresh token with the same value returned %v, want ErrConflict", err)
+	}
+
+	owner, err := s.GetUserByRefreshToken(params.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner.ID != user.ID {
+		t.Errorf("GetUserByRefreshToken returned %v, want %v", owner.ID, user.ID)
+	}
+
+	if err := s.RevokeRefreshToken(params.Token); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := s.GetRefreshToken(params.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt.RevokedAt == nil || rt.UserID != user.ID || !rt.ExpiresAt.Equal(params.ExpiresAt) {
+		t.Errorf("GetRefreshToken returned %+v", rt)
+	}
+
+	_, err = s.GetRefreshToken("no-such-token")
+	wantNotFound(t, "GetRefreshToken of an unknown token", err)
+	wantNotFound(t, "RevokeRefreshToken of an unknown token", s.RevokeRefreshToken("no-such-token"))
+	wantNotFound(t, "DeleteRefreshToken of an unknown token", s.DeleteRefreshToken("no-such-token"))
+
+	if err := s.DeleteRefreshToken(params.Token); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetRefreshToken(params.Token)
+	wantNotFound(t, "GetRefreshToken of a deleted token", err)
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotFound is returned when the row a method looks up, updates or
+// deletes doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned when a write would break a uniqueness constraint,
+// such as a second user with the same email.
+var ErrConflict = errors.New("conflicts with an existing record")
+
+// UserStore, VideoStore and RefreshTokenStore are the parts of the database
+// request handlers need, so they can run against a MemoryStore as well as a
+// Client. Both implementations behave the same: lookups, updates and
+// deletes of missing rows return ErrNotFound and duplicate keys ErrConflict.
+type UserStore interface {
+	CreateUser(params CreateUserParams) (*User, error)
+	GetUser(id uuid.UUID) (User, error)
+	GetUserByEmail(email string) (User, error)
+	GetUserByRefreshToken(token string) (*User, error)
+	GetUsers() ([]User, error)
+	DeleteUser(id uuid.UUID) error
+}
+
+type VideoStore interface {
+	CreateVideo(params CreateVideoParams) (Video, error)
+	GetVideo(id uuid.UUID) (Video, error)
+	GetVideos(userID uuid.UUID, q VideoQuery) (VideoPage, error)
+	GetAllVideos() ([]Video, error)
+	UpdateVideo(video Video) error
+}
+
+type RefreshTokenStore interface {
+	CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error)
+	GetRefreshToken(token string) (RefreshToken, error)
+	RevokeRefreshToken(token string) error
+	DeleteRefreshToken(token string) error
+}
+
+// BlobStore, VersionStore, ProcessingJobStore, DirectUploadStore,
+// CleanupTaskStore and VideoDeleter cover the rest of what handlers and their
+// workers use. Only a Client implements them.
+type BlobStore interface {
+	GetBlob(hash string) (Blob, error)
+	GetBlobs() ([]Blob, error)
+	GetUserBlob(userID uuid.UUID, hash string) (Blob, error)
+	AcquireBlob(blob Blob) (Blob, error)
+	ReuseBlob(hash, key string) (Blob, error)
+	ReleaseBlob(hash string, cleanup func(key string) []CleanupTask) (Blob, error)
+	AddBlobSource(sourceHash, hash string) error
+	CopyVideoMetadata(hash string, videoID uuid.UUID) error
+}
+
+type VersionStore interface {
+	ReplaceVideo(previous, video Video, retention VersionRetention) error
+	PromoteVideoVersion(previous, video Video, versionID uuid.UUID, retention VersionRetention) error
+	GetVideoVersion(id uuid.UUID) (VideoVersion, error)
+	GetVideoVersions(videoID uuid.UUID) ([]VideoVersion, error)
+	GetAllVideoVersions() ([]VideoVersion, error)
+	ExpireVideoVersion(now time.Time, cleanup func(key string) []CleanupTask) (version VideoVersion, ok bool, err error)
+}
+
+type ProcessingJobStore interface {
+	CreateProcessingJob(videoID uuid.UUID, sourcePath string) (ProcessingJob, error)
+	GetProcessingJob(id uuid.UUID) (ProcessingJob, error)
+	GetLatestProcessingJob(videoID uuid.UUID) (ProcessingJob, error)
+	ClaimProcessingJob(lockedUntil time.Time) (job ProcessingJob, ok bool, err error)
+	ExtendProcessingJobLease(id, lease uuid.UUID, lockedUntil time.Time) error
+	UpdateProcessingJobState(id, lease uuid.UUID, state ProcessingState, errMsg *string) error
+	GetAbandonedProcessingJobs(now time.Time) ([]ProcessingJob, error)
+	RecoverProcessingJob(id uuid.UUID, state ProcessingState, errMsg *string, now time.Time) error
+}
+
+type DirectUploadStore interface {
+	CreateDirectUpload(videoID uuid.UUID, key string) (DirectUpload, error)
+	GetDirectUpload(id uuid.UUID) (DirectUpload, error)
+	CompleteDirectUpload(id uuid.UUID, sourcePath string) (ProcessingJob, error)
+}
+
+// VideoDeleter deletes a video together with the rows in other stores that
+// refer to it, and queues cleanup of its objects, in one transaction.
+type VideoDeleter interface {
+	DeleteVideo(id uuid.UUID, cleanup []CleanupTask) error
+}
+
+type CleanupTaskStore interface {
+	CreateCleanupTasks(tasks []CleanupTask) error
+	GetDueCleanupTasks(now time.Time, limit int) ([]CleanupTask, error)
+	CompleteCleanupTask(id uuid.UUID) error
+	RetryCleanupTask(id uuid.UUID, errMsg string, next time.Time) error
+}
+
+var (
+	_ UserStore          = Client{}
+	_ VideoStore         = Client{}
+	_ RefreshTokenStore  = Client{}
+	_ UserStore          = (*MemoryStore)(nil)
+	_ VideoStore         = (*MemoryStore)(nil)
+	_ RefreshTokenStore  = (*MemoryStore)(nil)
+	_ BlobStore          = Client{}
+	_ VersionStore       = Client{}
+	_ ProcessingJobStore = Client{}
+	_ DirectUploadStore  = Client{}
+	_ CleanupTaskStore   = Client{}
+	_ VideoDeleter       = Client{}
+)
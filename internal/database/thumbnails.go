@@ -0,0 +1,68 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+type ThumbnailVariant struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Format string `json:"format"`
+	URL    string `json:"url"`
+}
+
+// ThumbnailSet lists every rendered size and format of a video's thumbnail.
+// It is stored as JSON in a single column.
+type ThumbnailSet struct {
+	Variants []ThumbnailVariant `json:"variants"`
+}
+
+func (s ThumbnailSet) Value() (driver.Value, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (s *ThumbnailSet) Scan(src any) error {
+	switch src := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(src), s)
+	case []byte:
+		return json.Unmarshal(src, s)
+	default:
+		return fmt.Errorf("can't scan %T into ThumbnailSet", src)
+	}
+}
+
+// StorageKeys are the keys of the objects a video has written to a store,
+// recorded so they can be deleted later. Stored as a JSON array.
+type StorageKeys []string
+
+func (k StorageKeys) Value() (driver.Value, error) {
+	if k == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal([]string(k))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (k *StorageKeys) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		*k = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(src), (*[]string)(k))
+	case []byte:
+		return json.Unmarshal(src, (*[]string)(k))
+	default:
+		return fmt.Errorf("can't scan %T into StorageKeys", src)
+	}
+}
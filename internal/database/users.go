@@ -3,6 +3,7 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -62,7 +63,7 @@ func (c Client) GetUserByEmail(email string) (User, error) {
 	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return User{}, nil
+			return User{}, ErrNotFound
 		}
 		return User{}, err
 	}
@@ -86,7 +87,7 @@ func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
+			return nil, ErrNotFound
 		}
 		return nil, err
 	}
@@ -109,13 +110,20 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 	`
 	_, err := c.db.Exec(query, id.String(), params.Email, params.Password)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, fmt.Errorf("%w: email %s is already registered", ErrConflict, params.Email)
+		}
 		return nil, err
 	}
 
-	return c.GetUser(id)
+	user, err := c.GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
 }
 
-func (c Client) GetUser(id uuid.UUID) (*User, error) {
+func (c Client) GetUser(id uuid.UUID) (User, error) {
 	query := `
 		SELECT id, created_at, updated_at, email, password
 		FROM users
@@ -126,15 +134,15 @@ func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
+			return User{}, ErrNotFound
 		}
-		return nil, err
+		return User{}, err
 	}
 	user.ID, err = uuid.Parse(idStr)
 	if err != nil {
-		return nil, err
+		return User{}, err
 	}
-	return &user, nil
+	return user, nil
 }
 
 func (c Client) DeleteUser(id uuid.UUID) error {
@@ -142,6 +150,5 @@ func (c Client) DeleteUser(id uuid.UUID) error {
 		DELETE FROM users
 		WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id.String())
-	return err
+	return expectRows(c.db.Exec(query, id.String()))
 }
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// VideoMetadata is the technical description of an uploaded video as
+// reported by ffprobe. Audio fields are zero for silent videos.
+type VideoMetadata struct {
+	Duration        float64 `json:"duration"`
+	Width           int     `json:"width"`
+	Height          int     `json:"height"`
+	VideoCodec      string  `json:"video_codec"`
+	AudioCodec      string  `json:"audio_codec"`
+	FrameRate       float64 `json:"frame_rate"`
+	BitRate         int64   `json:"bit_rate"`
+	AudioChannels   int     `json:"audio_channels"`
+	AudioSampleRate int     `json:"audio_sample_rate"`
+	Rotation        int     `json:"rotation"`
+	Container       string  `json:"container"`
+}
+
+// Value and Scan store a copy of the metadata as JSON, as video versions do.
+func (m VideoMetadata) Value() (driver.Value, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (m *VideoMetadata) Scan(src any) error {
+	switch src := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(src), m)
+	case []byte:
+		return json.Unmarshal(src, m)
+	default:
+		return fmt.Errorf("can't scan %T into VideoMetadata", src)
+	}
+}
+
+// UpsertVideoMetadata stores the metadata of a video's current upload,
+// replacing whatever an earlier upload recorded.
+func (c Client) UpsertVideoMetadata(videoID uuid.UUID, m VideoMetadata) error {
+	return upsertVideoMetadata(c.db, videoID, m)
+}
+
+func upsertVideoMetadata(db execer, videoID uuid.UUID, m VideoMetadata) error {
+	query := `
+	INSERT INTO video_metadata (
+		video_id,
+		created_at,
+		updated_at,
+		duration,
+		width,
+		height,
+		video_codec,
+		audio_codec,
+		frame_rate,
+		bit_rate,
+		audio_channels,
+		audio_sample_rate,
+		rotation,
+		container
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (video_id) DO UPDATE SET
+		updated_at = CURRENT_TIMESTAMP,
+		duration = excluded.duration,
+		width = excluded.width,
+		height = excluded.height,
+		video_codec = excluded.video_codec,
+		audio_codec = excluded.audio_codec,
+		frame_rate = excluded.frame_rate,
+		bit_rate = excluded.bit_rate,
+		audio_channels = excluded.audio_channels,
+		audio_sample_rate = excluded.audio_sample_rate,
+		rotation = excluded.rotation,
+		container = excluded.container
+	`
+	_, err := db.Exec(
+		query,
+		videoID,
+		m.Duration,
+		m.Width,
+		m.Height,
+		m.VideoCodec,
+		m.AudioCodec,
+		m.FrameRate,
+		m.BitRate,
+		m.AudioChannels,
+		m.AudioSampleRate,
+		m.Rotation,
+		m.Container,
+	)
+	return err
+}
@@ -0,0 +1,292 @@
+package database
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidCursor is returned by GetVideos for a cursor it didn't hand
+// out, or one handed out for a different sort.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// VideoFilter narrows GetVideos down. Zero fields don't filter; videos
+// without metadata don't match any of the metadata fields.
+type VideoFilter struct {
+	MinDuration float64
+	MaxDuration float64
+	MinWidth    int
+	MaxWidth    int
+	MinHeight   int
+	MaxHeight   int
+	VideoCodec  string
+	AudioCodec  string
+	Container   string
+	// HasVideo and HasThumbnail, when set, keep only videos that have, or
+	// don't have, an uploaded file or a thumbnail.
+	HasVideo     *bool
+	HasThumbnail *bool
+	// CreatedAfter and CreatedBefore are exclusive bounds on CreatedAt.
+	// SQLite keeps whole seconds, so they are compared as such.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// where returns the SQL conditions for f over the videos alias v and the
+// video_metadata alias m, each prefixed with AND, and their arguments.
+func (f VideoFilter) where(d dialect) (string, []any) {
+	var conditions []string
+	var args []any
+	add := func(condition string, arg any) {
+		conditions = append(conditions, condition)
+		args = append(args, arg)
+	}
+	if f.MinDuration > 0 {
+		add("m.duration >= ?", f.MinDuration)
+	}
+	if f.MaxDuration > 0 {
+		add("m.duration <= ?", f.MaxDuration)
+	}
+	if f.MinWidth > 0 {
+		add("m.width >= ?", f.MinWidth)
+	}
+	if f.MaxWidth > 0 {
+		add("m.width <= ?", f.MaxWidth)
+	}
+	if f.MinHeight > 0 {
+		add("m.height >= ?", f.MinHeight)
+	}
+	if f.MaxHeight > 0 {
+		add("m.height <= ?", f.MaxHeight)
+	}
+	if f.VideoCodec != "" {
+		add("m.video_codec = ?", f.VideoCodec)
+	}
+	if f.AudioCodec != "" {
+		add("m.audio_codec = ?", f.AudioCodec)
+	}
+	if f.Container != "" {
+		add("m.container = ?", f.Container)
+	}
+	if f.HasVideo != nil {
+		conditions = append(conditions, "v.video_url IS "+notNull(*f.HasVideo))
+	}
+	if f.HasThumbnail != nil {
+		conditions = append(conditions, "v.thumbnail_url IS "+notNull(*f.HasThumbnail))
+	}
+	if !f.CreatedAfter.IsZero() {
+		add("v.created_at > ?", d.timeArg(f.CreatedAfter))
+	}
+	if !f.CreatedBefore.IsZero() {
+		add("v.created_at < ?", d.timeArg(f.CreatedBefore))
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return "AND " + strings.Join(conditions, " AND "), args
+}
+
+func notNull(set bool) string {
+	if set {
+		return "NOT NULL"
+	}
+	return "NULL"
+}
+
+// matches is where evaluated in Go, for the MemoryStore.
+func (f VideoFilter) matches(video Video) bool {
+	m := video.Metadata
+	has := m != nil
+	return (f.MinDuration <= 0 || has && m.Duration >= f.MinDuration) &&
+		(f.MaxDuration <= 0 || has && m.Duration <= f.MaxDuration) &&
+		(f.MinWidth <= 0 || has && m.Width >= f.MinWidth) &&
+		(f.MaxWidth <= 0 || has && m.Width <= f.MaxWidth) &&
+		(f.MinHeight <= 0 || has && m.Height >= f.MinHeight) &&
+		(f.MaxHeight <= 0 || has && m.Height <= f.MaxHeight) &&
+		(f.VideoCodec == "" || has && m.VideoCodec == f.VideoCodec) &&
+		(f.AudioCodec == "" || has && m.AudioCodec == f.AudioCodec) &&
+		(f.Container == "" || has && m.Container == f.Container) &&
+		(f.HasVideo == nil || (video.VideoURL != nil) == *f.HasVideo) &&
+		(f.HasThumbnail == nil || (video.ThumbnailURL != nil) == *f.HasThumbnail) &&
+		(f.CreatedAfter.IsZero() || video.CreatedAt.After(f.CreatedAfter.Truncate(time.Second))) &&
+		(f.CreatedBefore.IsZero() || video.CreatedAt.Before(f.CreatedBefore.Truncate(time.Second)))
+}
+
+// VideoSort is what GetVideos orders by. Videos with the same value are
+// ordered by ID, so pages never overlap or skip.
+type VideoSort string
+
+const (
+	VideoSortCreated VideoSort = "created"
+	VideoSortUpdated VideoSort = "updated"
+	VideoSortTitle   VideoSort = "title"
+	// VideoSortDuration counts videos without metadata as 0 seconds long.
+	VideoSortDuration VideoSort = "duration"
+)
+
+func (s VideoSort) column() (string, bool) {
+	switch s {
+	case VideoSortCreated, "":
+		return "v.created_at", true
+	case VideoSortUpdated:
+		return "v.updated_at", true
+	case VideoSortTitle:
+		return "v.title", true
+	case VideoSortDuration:
+		return "COALESCE(m.duration, 0)", true
+	}
+	return "", false
+}
+
+// VideoQuery asks GetVideos for one page of a user's videos.
+type VideoQuery struct {
+	Filter    VideoFilter
+	Sort      VideoSort
+	Ascending bool
+	// Limit is the most videos on the page, 0 for all of them.
+	Limit int
+	// Cursor is the NextCursor of the previous page, empty for the first.
+	Cursor string
+}
+
+// VideoPage is one page of GetVideos. NextCursor is nil on the last page.
+type VideoPage struct {
+	Videos     []Video `json:"videos"`
+	NextCursor *string `json:"next_cursor"`
+}
+
+// videoCursor is the sort key and ID of the last video on a page. It is
+// handed out base64-encoded so clients treat it as opaque.
+type videoCursor struct {
+	Sort      VideoSort `json:"s"`
+	Ascending bool      `json:"a"`
+	Time      time.Time `json:"t"`
+	Title     string    `json:"n"`
+	Duration  float64   `json:"d"`
+	ID        uuid.UUID `json:"i"`
+}
+
+func (q VideoQuery) cursorAfter(video Video) string {
+	cursor := videoCursor{Sort: q.sort(), Ascending: q.Ascending, ID: video.ID}
+	switch cursor.Sort {
+	case VideoSortCreated:
+		cursor.Time = video.CreatedAt
+	case VideoSortUpdated:
+		cursor.Time = video.UpdatedAt
+	case VideoSortTitle:
+		cursor.Title = video.Title
+	case VideoSortDuration:
+		cursor.Duration = videoDuration(video)
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// cursor decodes q.Cursor, nil when q asks for the first page.
+func (q VideoQuery) cursor() (*videoCursor, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor videoCursor
+	err = json.Unmarshal(data, &cursor)
+	if err != nil || cursor.Sort != q.sort() || cursor.Ascending != q.Ascending {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+func (q VideoQuery) sort() VideoSort {
+	if q.Sort == "" {
+		return VideoSortCreated
+	}
+	return q.Sort
+}
+
+// key is the cursor's sort value as a query argument.
+func (c videoCursor) key(d dialect) any {
+	switch c.Sort {
+	case VideoSortTitle:
+		return c.Title
+	case VideoSortDuration:
+		return c.Duration
+	}
+	return d.timeArg(c.Time)
+}
+
+// video is a stand-in carrying the cursor's sort value, for comparing
+// against with compareVideos.
+func (c videoCursor) video() Video {
+	video := Video{ID: c.ID, CreatedAt: c.Time, UpdatedAt: c.Time}
+	video.Title = c.Title
+	video.Metadata = &VideoMetadata{Duration: c.Duration}
+	return video
+}
+
+// page trims videos, fetched with one extra to tell whether there are
+// more, down to q.Limit and sets NextCursor.
+func (q VideoQuery) page(videos []Video) VideoPage {
+	page := VideoPage{Videos: videos}
+	if q.Limit > 0 && len(videos) > q.Limit {
+		page.Videos = videos[:q.Limit]
+		next := q.cursorAfter(page.Videos[q.Limit-1])
+		page.NextCursor = &next
+	}
+	return page
+}
+
+func videoDuration(video Video) float64 {
+	if video.Metadata == nil {
+		return 0
+	}
+	return video.Metadata.Duration
+}
+
+// compareVideos orders a before b ascending by sort, then by ID, the way
+// the SQL ORDER BY does.
+func compareVideos(a, b Video, sort VideoSort) int {
+	var c int
+	switch sort {
+	case VideoSortUpdated:
+		c = a.UpdatedAt.Compare(b.UpdatedAt)
+	case VideoSortTitle:
+		c = strings.Compare(a.Title, b.Title)
+	case VideoSortDuration:
+		c = cmp.Compare(videoDuration(a), videoDuration(b))
+	default:
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	}
+	if c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID.String(), b.ID.String())
+}
+
+// orderBy returns the ORDER BY clause and, after cursor, the condition
+// and arguments that skip everything up to and including it.
+func (q VideoQuery) orderBy(d dialect, cursor *videoCursor) (order, after string, args []any, err error) {
+	column, ok := q.sort().column()
+	if !ok {
+		return "", "", nil, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+	direction, comparison := "DESC", "<"
+	if q.Ascending {
+		direction, comparison = "ASC", ">"
+	}
+	order = fmt.Sprintf("ORDER BY %s %s, v.id %s", column, direction, direction)
+	if cursor != nil {
+		after = fmt.Sprintf("AND (%s %s ? OR (%s = ? AND v.id %s ?))", column, comparison, column, comparison)
+		key := cursor.key(d)
+		args = []any{key, key, cursor.ID}
+	}
+	return order, after, args, nil
+}
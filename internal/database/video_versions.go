@@ -0,0 +1,358 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrVersionExpired is returned when promoting a version whose retention
+// has run out.
+var ErrVersionExpired = errors.New("video version has expired")
+
+// VideoVersion is a file uploaded for a video. The version the video
+// currently points at has no ExpiresAt; older ones stay retrievable, and
+// keep their reference on shared content, until ExpiresAt.
+type VideoVersion struct {
+	ID             uuid.UUID       `json:"id"`
+	VideoID        uuid.UUID       `json:"video_id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	ExpiresAt      *time.Time      `json:"expires_at"`
+	Current        bool            `json:"current"`
+	UploadedBy     uuid.UUID       `json:"uploaded_by"`
+	VideoURL       *string         `json:"video_url"`
+	HLSURL         *string         `json:"hls_url"`
+	DashURL        *string         `json:"dash_url"`
+	PreviewSprites *PreviewSprites `json:"preview_sprites"`
+	ContentHash    *string         `json:"content_hash"`
+	VideoKey       *string         `json:"-"`
+	Metadata       *VideoMetadata  `json:"metadata"`
+}
+
+// VersionRetention is how long and how many replaced versions a video keeps.
+type VersionRetention struct {
+	// Until is when versions replaced now expire.
+	Until time.Time
+	// Keep is the most versions, the current one included, kept per video.
+	// Older ones expire straight away. 0 doesn't limit the count.
+	Keep int
+}
+
+// versionOf captures the file video points at.
+func versionOf(video Video) VideoVersion {
+	return VideoVersion{
+		VideoID:        video.ID,
+		UploadedBy:     video.UserID,
+		VideoURL:       video.VideoURL,
+		HLSURL:         video.HLSURL,
+		DashURL:        video.DashURL,
+		PreviewSprites: video.PreviewSprites,
+		ContentHash:    video.ContentHash,
+		VideoKey:       video.VideoKey,
+		Metadata:       video.Metadata,
+	}
+}
+
+const videoVersionColumns = `
+	id,
+	video_id,
+	created_at,
+	expires_at,
+	uploaded_by,
+	video_url,
+	hls_url,
+	dash_url,
+	preview_sprites,
+	content_hash,
+	video_key,
+	metadata
+`
+
+func scanVideoVersion(row rowScanner) (VideoVersion, error) {
+	var version VideoVersion
+	var uploadedBy *uuid.UUID
+	err := row.Scan(
+		&version.ID,
+		&version.VideoID,
+		&version.CreatedAt,
+		&version.ExpiresAt,
+		&uploadedBy,
+		&version.VideoURL,
+		&version.HLSURL,
+		&version.DashURL,
+		&version.PreviewSprites,
+		&version.ContentHash,
+		&version.VideoKey,
+		&version.Metadata,
+	)
+	if uploadedBy != nil {
+		version.UploadedBy = *uploadedBy
+	}
+	version.Current = version.ExpiresAt == nil
+	return version, err
+}
+
+func insertVideoVersion(db execer, version VideoVersion) error {
+	query := `
+	INSERT INTO video_versions (` + videoVersionColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	var expiresAt *time.Time
+	if version.ExpiresAt != nil {
+		t := version.ExpiresAt.UTC()
+		expiresAt = &t
+	}
+	_, err := db.Exec(
+		query,
+		uuid.New(),
+		version.VideoID,
+		version.CreatedAt.UTC(),
+		expiresAt,
+		version.UploadedBy,
+		version.VideoURL,
+		version.HLSURL,
+		version.DashURL,
+		version.PreviewSprites,
+		version.ContentHash,
+		version.VideoKey,
+		version.Metadata,
+	)
+	return err
+}
+
+// retireCurrentVersion makes the version previous points at expire at
+// retention.Until. Videos uploaded before versions were recorded have no
+// row for it yet, so one is made up from previous.
+func retireCurrentVersion(tx *txn, previous Video, retention VersionRetention) error {
+	until := retention.Until.UTC()
+	result, err := tx.Exec(`
+	UPDATE video_versions
+	SET expires_at = ?
+	WHERE video_id = ? AND expires_at IS NULL
+	`, until, previous.ID)
+	if err != nil {
+		return err
+	}
+	retired, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if retired > 0 || previous.VideoURL == nil {
+		return nil
+	}
+	version := versionOf(previous)
+	version.CreatedAt = previous.UpdatedAt
+	version.ExpiresAt = &until
+	return insertVideoVersion(tx, version)
+}
+
+// limitVideoVersions expires the oldest replaced versions of a video beyond
+// retention.Keep.
+func limitVideoVersions(tx *txn, videoID uuid.UUID, retention VersionRetention) error {
+	if retention.Keep <= 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	query := `
+	UPDATE video_versions
+	SET expires_at = ?
+	WHERE video_id = ? AND expires_at > ? AND id NOT IN (
+		SELECT id FROM video_versions
+		WHERE video_id = ? AND expires_at > ?
+		ORDER BY created_at DESC, id
+		LIMIT ?
+	)
+	`
+	_, err := tx.Exec(query, now, videoID, now, videoID, now, retention.Keep-1)
+	return err
+}
+
+// ReplaceVideo saves video, which has been pointed at a newly uploaded file,
+// and its metadata, records the upload as the current version and retires
+// the version previous pointed at, all in one transaction, so the old file
+// is never lost track of.
+func (c Client) ReplaceVideo(previous, video Video, retention VersionRetention) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = updateVideo(tx, video)
+	if err != nil {
+		return err
+	}
+	if video.Metadata != nil {
+		err = upsertVideoMetadata(tx, video.ID, *video.Metadata)
+		if err != nil {
+			return err
+		}
+	}
+	err = retireCurrentVersion(tx, previous, retention)
+	if err != nil {
+		return err
+	}
+	upload := versionOf(video)
+	upload.CreatedAt = time.Now()
+	err = insertVideoVersion(tx, upload)
+	if err != nil {
+		return err
+	}
+	err = limitVideoVersions(tx, video.ID, retention)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PromoteVideoVersion saves video, which has been pointed back at the file
+// of version versionID, makes that version current again and retires the
+// one previous pointed at. It returns ErrVersionExpired if the version
+// expired in the meantime.
+func (c Client) PromoteVideoVersion(previous, video Video, versionID uuid.UUID, retention VersionRetention) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = updateVideo(tx, video)
+	if err != nil {
+		return err
+	}
+	if video.Metadata != nil {
+		err = upsertVideoMetadata(tx, video.ID, *video.Metadata)
+	} else {
+		_, err = tx.Exec("DELETE FROM video_metadata WHERE video_id = ?", video.ID)
+	}
+	if err != nil {
+		return err
+	}
+	err = retireCurrentVersion(tx, previous, retention)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(`
+	UPDATE video_versions
+	SET expires_at = NULL
+	WHERE id = ? AND video_id = ? AND expires_at > ?
+	`, versionID, video.ID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	promoted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if promoted == 0 {
+		return ErrVersionExpired
+	}
+	err = limitVideoVersions(tx, video.ID, retention)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) GetVideoVersion(id uuid.UUID) (VideoVersion, error) {
+	query := `SELECT ` + videoVersionColumns + `
+	FROM video_versions
+	WHERE id = ?
+	`
+	version, err := scanVideoVersion(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return VideoVersion{}, ErrNotFound
+		}
+		return VideoVersion{}, err
+	}
+	return version, nil
+}
+
+// GetVideoVersions returns the versions of a video, most recently uploaded
+// first.
+func (c Client) GetVideoVersions(videoID uuid.UUID) ([]VideoVersion, error) {
+	query := `SELECT ` + videoVersionColumns + `
+	FROM video_versions
+	WHERE video_id = ?
+	ORDER BY created_at DESC, id
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []VideoVersion{}
+	for rows.Next() {
+		version, err := scanVideoVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// GetAllVideoVersions returns every version of every video.
+func (c Client) GetAllVideoVersions() ([]VideoVersion, error) {
+	rows, err := c.db.Query(`SELECT ` + videoVersionColumns + ` FROM video_versions`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []VideoVersion{}
+	for rows.Next() {
+		version, err := scanVideoVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// ExpireVideoVersion removes one version whose retention ran out before
+// now. Shared content is released and the version's own objects are queued
+// for deletion with the tasks cleanup returns, all in one transaction. ok is
+// false when nothing has expired.
+func (c Client) ExpireVideoVersion(now time.Time, cleanup func(key string) []CleanupTask) (version VideoVersion, ok bool, err error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return VideoVersion{}, false, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	DELETE FROM video_versions
+	WHERE id = (
+		SELECT id FROM video_versions
+		WHERE expires_at <= ?
+		ORDER BY expires_at
+		LIMIT 1
+		` + c.db.dialect.skipLocked() + `
+	)
+	RETURNING ` + videoVersionColumns
+	version, err = scanVideoVersion(tx.QueryRow(query, now.UTC()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return VideoVersion{}, false, nil
+		}
+		return VideoVersion{}, false, err
+	}
+	if version.ContentHash != nil {
+		_, err = releaseBlob(tx, *version.ContentHash, cleanup)
+		// A blob that is already gone must not keep the version, which
+		// would be picked again on every run, from expiring.
+		if errors.Is(err, ErrNotFound) {
+			err = nil
+		}
+	} else if version.VideoKey != nil {
+		err = insertCleanupTasks(tx, cleanup(*version.VideoKey))
+	}
+	if err != nil {
+		return VideoVersion{}, false, err
+	}
+	return version, true, tx.Commit()
+}
@@ -13,7 +13,25 @@ type Video struct {
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	// ThumbnailGenerated is set when the thumbnail was extracted from the
+	// video rather than uploaded by the owner.
+	ThumbnailGenerated bool            `json:"thumbnail_generated"`
+	Thumbnails         *ThumbnailSet   `json:"thumbnails"`
+	VideoURL           *string         `json:"video_url"`
+	HLSURL             *string         `json:"hls_url"`
+	DashURL            *string         `json:"dash_url"`
+	PreviewSprites     *PreviewSprites `json:"preview_sprites"`
+	// ContentHash is the SHA-256 of the processed video, nil until one has
+	// been processed. See Blob.
+	ContentHash *string `json:"content_hash"`
+	// VideoKey and ThumbnailKeys are where the video's own objects are
+	// stored. Content shared through a blob is cleaned up by releasing the
+	// blob instead.
+	VideoKey      *string     `json:"-"`
+	ThumbnailKeys StorageKeys `json:"-"`
+	// Metadata is nil until the first upload has been probed. UpdateVideo
+	// ignores it; see UpsertVideoMetadata.
+	Metadata *VideoMetadata `json:"metadata"`
 	CreateVideoParams
 }
 
@@ -23,23 +41,133 @@ type CreateVideoParams struct {
 	UserID      uuid.UUID `json:"user_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
-	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
-	`
+const videoColumns = `
+	v.id,
+	v.created_at,
+	v.updated_at,
+	v.title,
+	v.description,
+	v.thumbnail_url,
+	v.thumbnail_generated,
+	v.thumbnails,
+	v.video_url,
+	v.hls_url,
+	v.dash_url,
+	v.preview_sprites,
+	v.content_hash,
+	v.video_key,
+	v.thumbnail_keys,
+	v.user_id,
+	m.video_id,
+	COALESCE(m.duration, 0),
+	COALESCE(m.width, 0),
+	COALESCE(m.height, 0),
+	COALESCE(m.video_codec, ''),
+	COALESCE(m.audio_codec, ''),
+	COALESCE(m.frame_rate, 0),
+	COALESCE(m.bit_rate, 0),
+	COALESCE(m.audio_channels, 0),
+	COALESCE(m.audio_sample_rate, 0),
+	COALESCE(m.rotation, 0),
+	COALESCE(m.container, '')
+`
+
+const videoFrom = `
+	FROM videos v
+	LEFT JOIN video_metadata m ON m.video_id = v.id
+`
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	var metadataVideoID sql.NullString
+	var metadata VideoMetadata
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.ThumbnailGenerated,
+		&video.Thumbnails,
+		&video.VideoURL,
+		&video.HLSURL,
+		&video.DashURL,
+		&video.PreviewSprites,
+		&video.ContentHash,
+		&video.VideoKey,
+		&video.ThumbnailKeys,
+		&video.UserID,
+		&metadataVideoID,
+		&metadata.Duration,
+		&metadata.Width,
+		&metadata.Height,
+		&metadata.VideoCodec,
+		&metadata.AudioCodec,
+		&metadata.FrameRate,
+		&metadata.BitRate,
+		&metadata.AudioChannels,
+		&metadata.AudioSampleRate,
+		&metadata.Rotation,
+		&metadata.Container,
+	)
+	if err != nil {
+		return Video{}, err
+	}
+	if metadataVideoID.Valid {
+		video.Metadata = &metadata
+	}
+	return video, nil
+}
+
+// GetVideos returns a page of a user's videos. Pages are keyed on the sort
+// value and ID of the last video, so uploads and deletes while paging don't
+// shift later pages.
+func (c Client) GetVideos(userID uuid.UUID, q VideoQuery) (VideoPage, error) {
+	cursor, err := q.cursor()
+	if err != nil {
+		return VideoPage{}, err
+	}
+	order, after, afterArgs, err := q.orderBy(c.db.dialect, cursor)
+	if err != nil {
+		return VideoPage{}, err
+	}
+	conditions, args := q.Filter.where(c.db.dialect)
+	query := `SELECT ` + videoColumns + videoFrom + `
+	WHERE v.user_id = ? ` + conditions + ` ` + after + `
+	` + order
+	args = append(append([]any{userID}, args...), afterArgs...)
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit+1)
+	}
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return VideoPage{}, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return VideoPage{}, err
+		}
+		videos = append(videos, video)
+	}
+	if err := rows.Err(); err != nil {
+		return VideoPage{}, err
+	}
+	return q.page(videos), nil
+}
 
-	rows, err := c.db.Query(query, userID)
+// GetAllVideos returns every video of every user.
+func (c Client) GetAllVideos() ([]Video, error) {
+	query := `SELECT ` + videoColumns + videoFrom + `
+	ORDER BY v.created_at
+	`
+	rows, err := c.db.Query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -47,23 +175,13 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
-
-	return videos, nil
+	return videos, rows.Err()
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
@@ -87,33 +205,14 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 }
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
-	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
-	FROM videos
-	WHERE id = ?
+	query := `SELECT ` + videoColumns + videoFrom + `
+	WHERE v.id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return Video{}, nil
+			return Video{}, ErrNotFound
 		}
 		return Video{}, err
 	}
@@ -122,34 +221,85 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 }
 
 func (c Client) UpdateVideo(video Video) error {
+	return updateVideo(c.db, video)
+}
+
+func updateVideo(db execer, video Video) error {
 	query := `
 	UPDATE videos
 	SET
+		updated_at = CURRENT_TIMESTAMP,
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
+		thumbnail_generated = ?,
+		thumbnails = ?,
 		video_url = ?,
+		hls_url = ?,
+		dash_url = ?,
+		preview_sprites = ?,
+		content_hash = ?,
+		video_key = ?,
+		thumbnail_keys = ?,
 		user_id = ?
 	WHERE id = ?
 	`
 
-	_, err := c.db.Exec(
+	return expectRows(db.Exec(
 		query,
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
+		video.ThumbnailGenerated,
+		video.Thumbnails,
 		&video.VideoURL,
+		&video.HLSURL,
+		&video.DashURL,
+		video.PreviewSprites,
+		video.ContentHash,
+		video.VideoKey,
+		video.ThumbnailKeys,
 		video.UserID,
 		video.ID,
-	)
-	return err
+	))
 }
 
-func (c Client) DeleteVideo(id uuid.UUID) error {
+// DeleteVideo removes a video with its processing jobs, metadata and
+// versions and queues cleanup of its stored objects in the same
+// transaction, so they can't be forgotten if the process dies.
+func (c Client) DeleteVideo(id uuid.UUID, cleanup []CleanupTask) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM processing_jobs WHERE video_id = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM video_metadata WHERE video_id = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM video_versions WHERE video_id = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM direct_uploads WHERE video_id = ?", id)
+	if err != nil {
+		return err
+	}
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
-	return err
+	err = expectRows(tx.Exec(query, id))
+	if err != nil {
+		return err
+	}
+	err = insertCleanupTasks(tx, cleanup)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
@@ -0,0 +1,126 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// stderrLimit caps how much of ffmpeg's stderr is kept for error messages.
+// The interesting part is at the end.
+const stderrLimit = 4 << 10
+
+// Exec runs the real ffmpeg and ffprobe binaries.
+type Exec struct {
+	FFmpegPath  string
+	FFprobePath string
+	// ProbeTimeout and TranscodeTimeout bound a single invocation on top of
+	// whatever deadline the caller's context carries. Zero means no limit.
+	ProbeTimeout     time.Duration
+	TranscodeTimeout time.Duration
+}
+
+// NewExec returns an Exec that finds ffmpeg and ffprobe on PATH.
+func NewExec(probeTimeout, transcodeTimeout time.Duration) *Exec {
+	return &Exec{
+		FFmpegPath:       "ffmpeg",
+		FFprobePath:      "ffprobe",
+		ProbeTimeout:     probeTimeout,
+		TranscodeTimeout: transcodeTimeout,
+	}
+}
+
+// ErrUnreadable is returned by Probe when ffprobe ran fine but the input
+// isn't a media file it can make sense of, as opposed to the toolchain
+// itself failing.
+var ErrUnreadable = errors.New("input is not a readable media file")
+
+// Error is a failed ffmpeg or ffprobe run. Stderr holds the tail of what
+// the tool printed.
+type Error struct {
+	Tool   string
+	Err    error
+	Stderr string
+}
+
+func (e *Error) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("%s failed: %v", e.Tool, e.Err)
+	}
+	return fmt.Sprintf("%s failed: %v: %s", e.Tool, e.Err, e.Stderr)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Exec) Probe(ctx context.Context, input string) (Metadata, error) {
+	args := []string{"-v", "error", "-print_format", "json", "-show_streams", "-show_format", input}
+	var stdout bytes.Buffer
+	err := e.run(ctx, e.ProbeTimeout, e.FFprobePath, args, nil, &stdout)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return Metadata{}, fmt.Errorf("%w: %w", ErrUnreadable, err)
+		}
+		return Metadata{}, err
+	}
+	var metadata Metadata
+	err = json.Unmarshal(stdout.Bytes(), &metadata)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("couldn't parse ffprobe output: %w", err)
+	}
+	if len(metadata.Streams) == 0 {
+		return Metadata{}, fmt.Errorf("%w: no streams found in %s", ErrUnreadable, input)
+	}
+	return metadata, nil
+}
+
+func (e *Exec) Run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	return e.run(ctx, e.TranscodeTimeout, e.FFmpegPath, args, stdin, stdout)
+}
+
+func (e *Exec) run(ctx context.Context, timeout time.Duration, tool string, args []string, stdin io.Reader, stdout io.Writer) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var stderr tailBuffer
+	cmd := exec.CommandContext(ctx, tool, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			// A killed process only reports "signal: killed".
+			err = fmt.Errorf("%w (%v)", ctxErr, err)
+		}
+		return &Error{Tool: tool, Err: err, Stderr: strings.TrimSpace(stderr.String())}
+	}
+	return nil
+}
+
+// tailBuffer keeps the last stderrLimit bytes written to it.
+type tailBuffer struct {
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > stderrLimit {
+		t.buf = t.buf[len(t.buf)-stderrLimit:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	return string(t.buf)
+}
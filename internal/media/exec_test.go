@@ -0,0 +1,80 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeTool writes a shell script standing in for ffmpeg or ffprobe.
+func fakeTool(t *testing.T, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	path := filepath.Join(t.TempDir(), "tool")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExecRunKeepsStderr(t *testing.T) {
+	ffmpeg := fakeTool(t, `echo "ffmpeg version 7.1" >&2
+echo "[mov,mp4 @ 0x1] moov atom not found" >&2
+echo "in.mp4: Invalid data found when processing input" >&2
+exit 1`)
+	e := &Exec{FFmpegPath: ffmpeg}
+
+	err := e.Run(context.Background(), []string{"-i", "in.mp4", "out.mp4"}, nil, nil)
+	var mediaErr *Error
+	if !errors.As(err, &mediaErr) {
+		t.Fatalf("Run returned %v, want a *media.Error", err)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("%v doesn't wrap the exit error", err)
+	}
+	if !strings.Contains(err.Error(), "in.mp4: Invalid data found when processing input") {
+		t.Errorf("error %q is missing ffmpeg's stderr", err)
+	}
+}
+
+func TestExecRunKeepsStderrTail(t *testing.T) {
+	ffmpeg := fakeTool(t, `i=0
+while [ $i -lt 500 ]; do echo "frame=$i fps=30 q=28.0 size=1kB" >&2; i=$((i+1)); done
+echo "Conversion failed!" >&2
+exit 1`)
+	e := &Exec{FFmpegPath: ffmpeg}
+
+	err := e.Run(context.Background(), nil, nil, nil)
+	var mediaErr *Error
+	if !errors.As(err, &mediaErr) {
+		t.Fatalf("Run returned %v, want a *media.Error", err)
+	}
+	if len(mediaErr.Stderr) > stderrLimit {
+		t.Errorf("kept %d bytes of stderr, limit is %d", len(mediaErr.Stderr), stderrLimit)
+	}
+	if !strings.HasSuffix(mediaErr.Stderr, "Conversion failed!") {
+		t.Errorf("stderr doesn't end with ffmpeg's last line: ...%q", mediaErr.Stderr[max(0, len(mediaErr.Stderr)-60):])
+	}
+}
+
+func TestExecProbeUnreadable(t *testing.T) {
+	ffprobe := fakeTool(t, `echo "not.mp4: Invalid data found when processing input" >&2
+exit 1`)
+	e := &Exec{FFprobePath: ffprobe}
+
+	_, err := e.Probe(context.Background(), "not.mp4")
+	if !errors.Is(err, ErrUnreadable) {
+		t.Errorf("Probe returned %v, want ErrUnreadable", err)
+	}
+	if !strings.Contains(err.Error(), "Invalid data found") {
+		t.Errorf("error %q is missing ffprobe's stderr", err)
+	}
+}
@@ -0,0 +1,68 @@
+package media
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Fake is a Prober and Transcoder that never starts a process. It records
+// every call so tests can assert on the ffmpeg arguments.
+type Fake struct {
+	// Metadata and ProbeErr are what Probe returns.
+	Metadata Metadata
+	ProbeErr error
+	// RunFunc, if set, handles Run. Otherwise Run creates an empty file at
+	// the output path, the last argument, unless it is a pipe or a pattern.
+	RunFunc func(args []string, stdin io.Reader, stdout io.Writer) error
+
+	mu     sync.Mutex
+	probes []string
+	runs   [][]string
+}
+
+func (f *Fake) Probe(ctx context.Context, input string) (Metadata, error) {
+	f.mu.Lock()
+	f.probes = append(f.probes, input)
+	f.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return Metadata{}, err
+	}
+	return f.Metadata, f.ProbeErr
+}
+
+func (f *Fake) Run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	f.mu.Lock()
+	f.runs = append(f.runs, append([]string(nil), args...))
+	f.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if f.RunFunc != nil {
+		return f.RunFunc(args, stdin, stdout)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	output := args[len(args)-1]
+	if strings.HasPrefix(output, "pipe:") || strings.Contains(output, "%") {
+		return nil
+	}
+	return os.WriteFile(output, nil, 0644)
+}
+
+// Probes returns the inputs Probe was called with, in order.
+func (f *Fake) Probes() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.probes...)
+}
+
+// Runs returns the arguments of every Run call, in order.
+func (f *Fake) Runs() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]string(nil), f.runs...)
+}
@@ -0,0 +1,152 @@
+// Package media wraps the ffmpeg toolchain behind small interfaces so the
+// processing pipeline can run against a fake in tests.
+package media
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// Prober inspects media files. input may be a local path or any URL ffprobe
+// can read.
+type Prober interface {
+	Probe(ctx context.Context, input string) (Metadata, error)
+}
+
+// Transcoder runs ffmpeg. args are everything after the binary name,
+// including inputs and outputs. stdin and stdout may be nil; ffmpeg can read
+// and write them as "pipe:0" and "pipe:1".
+type Transcoder interface {
+	Run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error
+}
+
+// Metadata is ffprobe's -show_streams -show_format JSON output.
+type Metadata struct {
+	Streams []struct {
+		Index              int    `json:"index"`
+		CodecName          string `json:"codec_name,omitempty"`
+		CodecLongName      string `json:"codec_long_name,omitempty"`
+		Profile            string `json:"profile,omitempty"`
+		CodecType          string `json:"codec_type"`
+		CodecTagString     string `json:"codec_tag_string"`
+		CodecTag           string `json:"codec_tag"`
+		Width              int    `json:"width,omitempty"`
+		Height             int    `json:"height,omitempty"`
+		CodedWidth         int    `json:"coded_width,omitempty"`
+		CodedHeight        int    `json:"coded_height,omitempty"`
+		ClosedCaptions     int    `json:"closed_captions,omitempty"`
+		FilmGrain          int    `json:"film_grain,omitempty"`
+		HasBFrames         int    `json:"has_b_frames,omitempty"`
+		SampleAspectRatio  string `json:"sample_aspect_ratio,omitempty"`
+		DisplayAspectRatio string `json:"display_aspect_ratio,omitempty"`
+		PixFmt             string `json:"pix_fmt,omitempty"`
+		Level              int    `json:"level,omitempty"`
+		ColorRange         string `json:"color_range,omitempty"`
+		ColorSpace         string `json:"color_space,omitempty"`
+		ColorTransfer      string `json:"color_transfer,omitempty"`
+		ColorPrimaries     string `json:"color_primaries,omitempty"`
+		ChromaLocation     string `json:"chroma_location,omitempty"`
+		FieldOrder         string `json:"field_order,omitempty"`
+		Refs               int    `json:"refs,omitempty"`
+		IsAvc              string `json:"is_avc,omitempty"`
+		NalLengthSize      string `json:"nal_length_size,omitempty"`
+		Id                 string `json:"id"`
+		RFrameRate         string `json:"r_frame_rate"`
+		AvgFrameRate       string `json:"avg_frame_rate"`
+		TimeBase           string `json:"time_base"`
+		StartPts           int    `json:"start_pts"`
+		StartTime          string `json:"start_time"`
+		DurationTs         int    `json:"duration_ts"`
+		Duration           string `json:"duration"`
+		BitRate            string `json:"bit_rate,omitempty"`
+		BitsPerRawSample   string `json:"bits_per_raw_sample,omitempty"`
+		NbFrames           string `json:"nb_frames"`
+		ExtradataSize      int    `json:"extradata_size"`
+		Disposition        struct {
+			Default         int `json:"default"`
+			Dub             int `json:"dub"`
+			Original        int `json:"original"`
+			Comment         int `json:"comment"`
+			Lyrics          int `json:"lyrics"`
+			Karaoke         int `json:"karaoke"`
+			Forced          int `json:"forced"`
+			HearingImpaired int `json:"hearing_impaired"`
+			VisualImpaired  int `json:"visual_impaired"`
+			CleanEffects    int `json:"clean_effects"`
+			AttachedPic     int `json:"attached_pic"`
+			TimedThumbnails int `json:"timed_thumbnails"`
+			NonDiegetic     int `json:"non_diegetic"`
+			Captions        int `json:"captions"`
+			Descriptions    int `json:"descriptions"`
+			Metadata        int `json:"metadata"`
+			Dependent       int `json:"dependent"`
+			StillImage      int `json:"still_image"`
+			Multilayer      int `json:"multilayer"`
+		} `json:"disposition"`
+		Tags struct {
+			Language    string `json:"language"`
+			HandlerName string `json:"handler_name"`
+			VendorId    string `json:"vendor_id,omitempty"`
+			Encoder     string `json:"encoder,omitempty"`
+			Timecode    string `json:"timecode,omitempty"`
+			Rotate      string `json:"rotate,omitempty"`
+		} `json:"tags"`
+		SideDataList   []SideData `json:"side_data_list,omitempty"`
+		SampleFmt      string     `json:"sample_fmt,omitempty"`
+		SampleRate     string     `json:"sample_rate,omitempty"`
+		Channels       int        `json:"channels,omitempty"`
+		ChannelLayout  string     `json:"channel_layout,omitempty"`
+		BitsPerSample  int        `json:"bits_per_sample,omitempty"`
+		InitialPadding int        `json:"initial_padding,omitempty"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		Size       string `json:"size"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+type SideData struct {
+	SideDataType string `json:"side_data_type"`
+	Rotation     int    `json:"rotation,omitempty"`
+}
+
+// SniffHeaderSize is how much of the start of a file SniffContainer needs.
+const SniffHeaderSize = 512
+
+// SniffContainer identifies the container format from the first bytes of a
+// file, independent of its name or claimed MIME type. It returns "" for
+// anything that isn't a recognised video container.
+func SniffContainer(header []byte) string {
+	switch {
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		if string(header[8:12]) == "qt  " {
+			return "mov"
+		}
+		return "mp4"
+	case len(header) >= 8 && isQuickTimeAtom(string(header[4:8])):
+		// Old QuickTime files start straight with a top-level atom.
+		return "mov"
+	case bytes.HasPrefix(header, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		// EBML; the DocType element near the start tells WebM from Matroska.
+		if bytes.Contains(header[:min(len(header), 64)], []byte("webm")) {
+			return "webm"
+		}
+		return "mkv"
+	case len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "AVI ":
+		return "avi"
+	case len(header) >= 189 && header[0] == 0x47 && header[188] == 0x47:
+		return "mpegts"
+	}
+	return ""
+}
+
+func isQuickTimeAtom(name string) bool {
+	switch name {
+	case "moov", "mdat", "wide", "free", "skip":
+		return true
+	}
+	return false
+}
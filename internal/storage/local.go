@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore keeps objects as plain files under root, which is expected to be
+// served over HTTP at baseURL.
+type LocalStore struct {
+	root    string
+	baseURL string
+}
+
+func NewLocalStore(root, baseURL string) (*LocalStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalStore{
+		root:    root,
+		baseURL: baseURL,
+	}, nil
+}
+
+func (s *LocalStore) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(cleaned)), nil
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	dst, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, ObjectInfo{}, translateFSError(err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, ObjectInfo{}, err
+	}
+	return f, fileInfo(key, stat), nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStore) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	stat, err := os.Stat(p)
+	if err != nil {
+		return ObjectInfo{}, translateFSError(err)
+	}
+	return fileInfo(key, stat), nil
+}
+
+func (s *LocalStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, fileInfo(key, stat))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func (s *LocalStore) URL(key string) string {
+	return joinURL(s.baseURL, key)
+}
+
+func fileInfo(key string, stat fs.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		ContentType:  mime.TypeByExtension(path.Ext(key)),
+		LastModified: stat.ModTime(),
+	}
+}
+
+func translateFSError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+// MemoryStore keeps objects in process memory. It is meant for tests and
+// throwaway local runs; nothing survives a restart.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+	baseURL string
+}
+
+func NewMemoryStore(baseURL string) *MemoryStore {
+	return &MemoryStore{
+		objects: map[string]memoryObject{},
+		baseURL: baseURL,
+	}
+}
+
+func (s *MemoryStore) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = memoryObject{
+		data:        data,
+		contentType: contentType,
+		modTime:     time.Now().UTC(),
+	}
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, ObjectInfo{}, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), obj.info(key), nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *MemoryStore) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return ObjectInfo{}, ErrNotFound
+	}
+	return obj.info(key), nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	objects := []ObjectInfo{}
+	for key, obj := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, obj.info(key))
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects, nil
+}
+
+func (s *MemoryStore) URL(key string) string {
+	return joinURL(s.baseURL, key)
+}
+
+func (o memoryObject) info(key string) ObjectInfo {
+	return ObjectInfo{
+		Key:          key,
+		Size:         int64(len(o.data)),
+		ContentType:  o.contentType,
+		LastModified: o.modTime,
+	}
+}
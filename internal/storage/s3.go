@@ -0,0 +1,477 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// maxCopyObjectSize is the largest object CopyObject accepts; anything
+// bigger has to be copied part by part.
+const maxCopyObjectSize = 5 << 30
+
+const copyPartSize = 1 << 30
+
+const (
+	minPartSize = 5 << 20
+	maxParts    = 10000
+)
+
+// smallPutSize is read before Put commits to a whole part buffer, so the
+// thumbnails and other small objects stay cheap.
+const smallPutSize = 64 << 10
+
+// S3Options tunes how Put transfers objects. Bodies larger than PartSize are
+// sent as a multipart upload with Concurrency parts in flight, each retried
+// up to MaxRetries times with exponential backoff starting at RetryBackoff.
+type S3Options struct {
+	PartSize     int64
+	Concurrency  int
+	MaxRetries   int
+	RetryBackoff time.Duration
+}
+
+func (o S3Options) withDefaults() S3Options {
+	if o.PartSize < minPartSize {
+		o.PartSize = 16 << 20
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 4
+	}
+	if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = 500 * time.Millisecond
+	}
+	return o
+}
+
+type S3Store struct {
+	client    *s3.Client
+	presigner *s3.PresignClient
+	bucket    string
+	baseURL   string
+	opts      S3Options
+	// partBufs holds *[]byte of PartSize, reused across parts and Puts.
+	partBufs sync.Pool
+}
+
+func NewS3Store(client *s3.Client, bucket, baseURL string, opts S3Options) *S3Store {
+	s := &S3Store{
+		client:    client,
+		presigner: s3.NewPresignClient(client),
+		bucket:    bucket,
+		baseURL:   baseURL,
+		opts:      opts.withDefaults(),
+	}
+	s.partBufs.New = func() any {
+		buf := make([]byte, s.opts.PartSize)
+		return &buf
+	}
+	return s
+}
+
+// Put reads up to one part of body to decide between a single PutObject and
+// a parallel multipart upload, so callers never need to know the size.
+func (s *S3Store) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	small := make([]byte, smallPutSize)
+	n, err := io.ReadFull(body, small)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return s.putObject(ctx, key, small[:n], contentType)
+	}
+	if err != nil {
+		return err
+	}
+
+	buf := s.partBufs.Get().(*[]byte)
+	copy(*buf, small)
+	m, err := io.ReadFull(body, (*buf)[n:])
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		defer s.partBufs.Put(buf)
+		return s.putObject(ctx, key, (*buf)[:n+m], contentType)
+	}
+	if err != nil {
+		s.partBufs.Put(buf)
+		return err
+	}
+	return s.putMultipart(ctx, key, uploadPart{number: 1, buf: buf, data: *buf}, body, contentType)
+}
+
+func (s *S3Store) putObject(ctx context.Context, key string, data []byte, contentType string) error {
+	return s.retry(ctx, func() error {
+		_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(s.bucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(data),
+			ContentType: aws.String(contentType),
+		})
+		return err
+	})
+}
+
+// uploadPart is one part of a multipart Put. data is a prefix of buf, which
+// goes back to partBufs once the part is uploaded or abandoned.
+type uploadPart struct {
+	number int32
+	buf    *[]byte
+	data   []byte
+}
+
+func (s *S3Store) putMultipart(ctx context.Context, key string, first uploadPart, rest io.Reader, contentType string) (err error) {
+	uploadID, err := s.CreateMultipartUpload(ctx, key, contentType)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// The request context may already be cancelled; the abort must still
+		// go out or S3 keeps billing for the uploaded parts.
+		abortCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if abortErr := s.AbortMultipartUpload(abortCtx, key, uploadID); abortErr != nil {
+			err = errors.Join(err, fmt.Errorf("couldn't abort multipart upload %s: %w", uploadID, abortErr))
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu        sync.Mutex
+		completed []CompletedPart
+		uploadErr error
+		wg        sync.WaitGroup
+	)
+	parts := make(chan uploadPart)
+	for range s.opts.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range parts {
+				etag, err := s.uploadPart(ctx, key, uploadID, part)
+				s.partBufs.Put(part.buf)
+				mu.Lock()
+				if err != nil && uploadErr == nil {
+					uploadErr = fmt.Errorf("part %d: %w", part.number, err)
+					cancel()
+				}
+				if err == nil {
+					completed = append(completed, CompletedPart{PartNumber: part.number, ETag: etag})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	readErr := func() error {
+		part := first
+		for {
+			if part.number > maxParts {
+				s.partBufs.Put(part.buf)
+				return fmt.Errorf("object exceeds %d parts of %d bytes", maxParts, s.opts.PartSize)
+			}
+			select {
+			case parts <- part:
+			case <-ctx.Done():
+				s.partBufs.Put(part.buf)
+				return ctx.Err()
+			}
+			buf := s.partBufs.Get().(*[]byte)
+			n, err := io.ReadFull(rest, *buf)
+			if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+				s.partBufs.Put(buf)
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
+			}
+			part = uploadPart{number: part.number + 1, buf: buf, data: (*buf)[:n]}
+		}
+	}()
+	close(parts)
+	wg.Wait()
+
+	if uploadErr != nil {
+		return uploadErr
+	}
+	if readErr != nil {
+		return readErr
+	}
+	return s.CompleteMultipartUpload(ctx, key, uploadID, completed)
+}
+
+func (s *S3Store) uploadPart(ctx context.Context, key, uploadID string, part uploadPart) (string, error) {
+	var etag string
+	err := s.retry(ctx, func() error {
+		out, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(s.bucket),
+			Key:           aws.String(key),
+			UploadId:      aws.String(uploadID),
+			PartNumber:    aws.Int32(part.number),
+			Body:          bytes.NewReader(part.data),
+			ContentLength: aws.Int64(int64(len(part.data))),
+		})
+		if err != nil {
+			return err
+		}
+		etag = aws.ToString(out.ETag)
+		return nil
+	})
+	return etag, err
+}
+
+func (s *S3Store) retry(ctx context.Context, fn func() error) error {
+	backoff := s.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= s.opts.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+		jitter := time.Duration(rand.Int64N(int64(backoff)/2 + 1))
+		select {
+		case <-time.After(backoff + jitter):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// AbortStaleMultipartUploads aborts every in-progress multipart upload in the
+// bucket that was started more than maxAge ago and returns how many it
+// aborted. Uploads abandoned by crashed processes or browsers otherwise keep
+// their parts stored, and billed, indefinitely.
+func (s *S3Store) AbortStaleMultipartUploads(ctx context.Context, maxAge time.Duration) (int, error) {
+	cutoff := time.Now().Add(-maxAge)
+	paginator := s3.NewListMultipartUploadsPaginator(s.client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.bucket),
+	})
+	aborted := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return aborted, err
+		}
+		for _, upload := range page.Uploads {
+			if upload.Initiated == nil || upload.Initiated.After(cutoff) {
+				continue
+			}
+			err := s.AbortMultipartUpload(ctx, aws.ToString(upload.Key), aws.ToString(upload.UploadId))
+			if err != nil {
+				return aborted, err
+			}
+			aborted++
+		}
+	}
+	return aborted, nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, ObjectInfo{}, translateS3Error(err)
+	}
+	return out.Body, ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		ContentType:  aws.ToString(out.ContentType),
+		LastModified: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return translateS3Error(err)
+}
+
+func (s *S3Store) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return ObjectInfo{}, translateS3Error(err)
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		ContentType:  aws.ToString(out.ContentType),
+		LastModified: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	objects := []ObjectInfo{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (s *S3Store) URL(key string) string {
+	return joinURL(s.baseURL, key)
+}
+
+func (s *S3Store) PresignPut(ctx context.Context, key, contentType string, ttl time.Duration) (string, error) {
+	req, err := s.presigner.PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (s *S3Store) PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	req, err := s.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (s *S3Store) CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	out, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.UploadId), nil
+}
+
+func (s *S3Store) PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, ttl time.Duration) (string, error) {
+	req, err := s.presigner.PresignUploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int32(partNumber),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (s *S3Store) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, types.CompletedPart{
+			PartNumber: aws.Int32(part.PartNumber),
+			ETag:       aws.String(part.ETag),
+		})
+	}
+	sort.Slice(completed, func(i, j int) bool {
+		return aws.ToInt32(completed[i].PartNumber) < aws.ToInt32(completed[j].PartNumber)
+	})
+	_, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	return err
+}
+
+func (s *S3Store) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return err
+}
+
+func (s *S3Store) Copy(ctx context.Context, srcKey, dstKey string) error {
+	info, err := s.Head(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	source := s.bucket + "/" + srcKey
+	if info.Size <= maxCopyObjectSize {
+		_, err = s.client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(s.bucket),
+			Key:        aws.String(dstKey),
+			CopySource: aws.String(source),
+		})
+		return err
+	}
+
+	uploadID, err := s.CreateMultipartUpload(ctx, dstKey, info.ContentType)
+	if err != nil {
+		return err
+	}
+	parts := []CompletedPart{}
+	for start, partNumber := int64(0), int32(1); start < info.Size; start, partNumber = start+copyPartSize, partNumber+1 {
+		end := min(start+copyPartSize, info.Size) - 1
+		out, err := s.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(s.bucket),
+			Key:             aws.String(dstKey),
+			UploadId:        aws.String(uploadID),
+			PartNumber:      aws.Int32(partNumber),
+			CopySource:      aws.String(source),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		if err != nil {
+			s.AbortMultipartUpload(context.Background(), dstKey, uploadID)
+			return err
+		}
+		parts = append(parts, CompletedPart{
+			PartNumber: partNumber,
+			ETag:       aws.ToString(out.CopyPartResult.ETag),
+		})
+	}
+	return s.CompleteMultipartUpload(ctx, dstKey, uploadID, parts)
+}
+
+func translateS3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeS3 implements just enough of the S3 API for Put: PutObject and the
+// multipart upload calls, for a single bucket.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	parts   map[int][]byte
+	puts    int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.parts = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>%s</Key><UploadId>upload</UploadId></InitiateMultipartUploadResult>", key)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		f.parts[number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, number))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		var complete struct {
+			Parts []struct{ PartNumber int } `xml:"Part"`
+		}
+		xml.Unmarshal(body, &complete)
+		var numbers []int
+		for _, part := range complete.Parts {
+			numbers = append(numbers, part.PartNumber)
+		}
+		sort.Ints(numbers)
+		var object []byte
+		for _, number := range numbers {
+			object = append(object, f.parts[number]...)
+		}
+		f.objects[key] = object
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>", key)
+	case r.Method == http.MethodPut:
+		f.puts++
+		f.objects[key] = body
+	default:
+		http.Error(w, "unexpected request", http.StatusNotImplemented)
+	}
+}
+
+func newFakeS3Store(t *testing.T) (*S3Store, *fakeS3) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client := s3.New(s3.Options{
+		Region:                     "us-east-1",
+		BaseEndpoint:               aws.String(server.URL),
+		UsePathStyle:               true,
+		Credentials:                aws.AnonymousCredentials{},
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+	})
+	return NewS3Store(client, "bucket", server.URL+"/bucket", S3Options{PartSize: minPartSize}), fake
+}
+
+func TestS3StorePut(t *testing.T) {
+	sizes := map[string]int{
+		"empty":               0,
+		"small":               1000,
+		"exactly small":       smallPutSize,
+		"under one part":      minPartSize - 1,
+		"exactly one part":    minPartSize,
+		"several parts":       2*minPartSize + 12345,
+		"exactly three parts": 3 * minPartSize,
+	}
+	for name, size := range sizes {
+		t.Run(name, func(t *testing.T) {
+			store, fake := newFakeS3Store(t)
+			data := bytes.Repeat([]byte("0123456789abcdef"), size/16+1)[:size]
+			err := store.Put(context.Background(), "landscape/a.mp4", bytes.NewReader(data), "video/mp4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(fake.objects["landscape/a.mp4"], data) {
+				t.Errorf("stored %d bytes, want the %d put", len(fake.objects["landscape/a.mp4"]), size)
+			}
+			if multipart := size >= minPartSize; multipart == (fake.puts == 1) {
+				t.Errorf("%d PutObject calls for %d bytes", fake.puts, size)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// BlobStore is the storage backend used for every uploaded asset. Keys are
+// slash separated paths such as "landscape/<id>.mp4".
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	Head(ctx context.Context, key string) (ObjectInfo, error)
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	URL(key string) string
+}
+
+// Presigner is implemented by stores that let clients move bytes directly to
+// and from the backend with short-lived signed URLs.
+type Presigner interface {
+	PresignPut(ctx context.Context, key, contentType string, ttl time.Duration) (string, error)
+	PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error)
+	CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error)
+	PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, ttl time.Duration) (string, error)
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+}
+
+type CompletedPart struct {
+	PartNumber int32  `json:"part_number"`
+	ETag       string `json:"etag"`
+}
+
+// Copier is implemented by stores that can copy an object without routing
+// its bytes through this process.
+type Copier interface {
+	Copy(ctx context.Context, srcKey, dstKey string) error
+}
+
+// Copy duplicates srcKey to dstKey, server side when the store supports it.
+func Copy(ctx context.Context, store BlobStore, srcKey, dstKey string) error {
+	if copier, ok := store.(Copier); ok {
+		return copier.Copy(ctx, srcKey, dstKey)
+	}
+	body, info, err := store.Get(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return store.Put(ctx, dstKey, body, info.ContentType)
+}
+
+func joinURL(baseURL, key string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + strings.TrimPrefix(key, "/")
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) BlobStore{
+		"local": func(t *testing.T) BlobStore {
+			store, err := NewLocalStore(t.TempDir(), "https://cdn.example.com/assets/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		},
+		"memory": func(t *testing.T) BlobStore {
+			return NewMemoryStore("https://cdn.example.com/assets/")
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			t.Run("PutGet", func(t *testing.T) { testPutGet(t, newStore(t)) })
+			t.Run("Delete", func(t *testing.T) { testDelete(t, newStore(t)) })
+			t.Run("List", func(t *testing.T) { testList(t, newStore(t)) })
+			t.Run("URL", func(t *testing.T) { testURL(t, newStore(t)) })
+		})
+	}
+}
+
+func testPutGet(t *testing.T, store BlobStore) {
+	ctx := context.Background()
+	err := store.Put(ctx, "landscape/a.mp4", strings.NewReader("first"), "video/mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Put(ctx, "landscape/a.mp4", strings.NewReader("second"), "video/mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, info, err := store.Get(ctx, "landscape/a.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "second" {
+		t.Errorf("Get returned %q, want the overwritten %q", data, "second")
+	}
+	if info.Size != 6 || info.ContentType != "video/mp4" {
+		t.Errorf("Get info = %+v, want size 6 and video/mp4", info)
+	}
+
+	head, err := store.Head(ctx, "landscape/a.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Key != "landscape/a.mp4" || head.Size != 6 {
+		t.Errorf("Head = %+v", head)
+	}
+
+	_, _, err = store.Get(ctx, "landscape/missing.mp4")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing key returned %v, want ErrNotFound", err)
+	}
+	_, err = store.Head(ctx, "landscape/missing.mp4")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Head of a missing key returned %v, want ErrNotFound", err)
+	}
+}
+
+func testDelete(t *testing.T, store BlobStore) {
+	ctx := context.Background()
+	err := store.Put(ctx, "thumbnails/a.jpg", strings.NewReader("jpg"), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "thumbnails/a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Head(ctx, "thumbnails/a.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Head after Delete returned %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, "thumbnails/a.jpg"); err != nil {
+		t.Errorf("deleting a missing key returned %v, want nil", err)
+	}
+}
+
+func testList(t *testing.T, store BlobStore) {
+	ctx := context.Background()
+	for _, key := range []string{"landscape/a.mp4", "landscape/a/index.m3u8", "portrait/b.mp4"} {
+		if err := store.Put(ctx, key, strings.NewReader(key), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	objects, err := store.List(ctx, "landscape/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]bool{}
+	for _, obj := range objects {
+		keys[obj.Key] = true
+	}
+	if len(keys) != 2 || !keys["landscape/a.mp4"] || !keys["landscape/a/index.m3u8"] {
+		t.Errorf("List(landscape/) = %v", objects)
+	}
+}
+
+func testURL(t *testing.T, store BlobStore) {
+	tests := map[string]string{
+		"landscape/a.mp4":  "https://cdn.example.com/assets/landscape/a.mp4",
+		"/landscape/a.mp4": "https://cdn.example.com/assets/landscape/a.mp4",
+	}
+	for key, want := range tests {
+		if got := store.URL(key); got != want {
+			t.Errorf("URL(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestLocalStoreKeepsKeysUnderRoot(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "assets")
+	store, err := NewLocalStore(root, "http://localhost:8091/assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	err = store.Put(ctx, "../../escape.txt", strings.NewReader("x"), "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "escape.txt")); err != nil {
+		t.Errorf("key with .. wasn't stored under the root: %v", err)
+	}
+	if err := store.Put(ctx, "/", strings.NewReader("x"), ""); err == nil {
+		t.Error("Put of an empty key succeeded")
+	}
+}
@@ -0,0 +1,174 @@
+// Package thumbnails validates uploaded thumbnail images and renders the
+// fixed set of sizes and formats served to clients.
+package thumbnails
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type")
+	ErrTooLarge        = errors.New("image dimensions too large")
+	ErrCorrupt         = errors.New("image data is corrupt")
+)
+
+// MaxDimension bounds either side of an accepted image. Decoding allocates
+// width*height*4 bytes, so this also caps memory use per upload.
+const MaxDimension = 8192
+
+var decodableTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// Decode sniffs the real type of data, ignoring whatever the client claimed,
+// checks its dimensions before allocating pixels and decodes it. It returns
+// the image and its sniffed media type.
+func Decode(data []byte) (image.Image, string, error) {
+	mediaType := http.DetectContentType(data)
+	if !decodableTypes[mediaType] {
+		return nil, "", fmt.Errorf("%w: %s", ErrUnsupportedType, mediaType)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, "", fmt.Errorf("%w: image has no pixels", ErrCorrupt)
+	}
+	if config.Width > MaxDimension || config.Height > MaxDimension {
+		return nil, "", fmt.Errorf("%w: %dx%d exceeds %dx%d", ErrTooLarge, config.Width, config.Height, MaxDimension, MaxDimension)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return img, mediaType, nil
+}
+
+type Size struct {
+	Width  int
+	Height int
+}
+
+func (s Size) String() string {
+	return fmt.Sprintf("%dx%d", s.Width, s.Height)
+}
+
+var (
+	LandscapeSizes = []Size{{1280, 720}, {640, 360}, {320, 180}}
+	PortraitSizes  = []Size{{720, 1280}, {360, 640}, {180, 320}}
+)
+
+// SizesFor returns the sizes to render for an image with the given bounds,
+// largest first. Portrait images get portrait sizes. Sizes that would need
+// upscaling are dropped, but the smallest one is always kept.
+func SizesFor(bounds image.Rectangle) []Size {
+	sizes := LandscapeSizes
+	if bounds.Dy() > bounds.Dx() {
+		sizes = PortraitSizes
+	}
+	fit := []Size{}
+	for _, size := range sizes {
+		if size.Width <= bounds.Dx() && size.Height <= bounds.Dy() {
+			fit = append(fit, size)
+		}
+	}
+	if len(fit) == 0 {
+		fit = append(fit, sizes[len(sizes)-1])
+	}
+	return fit
+}
+
+// Resize scales img to cover size exactly, cropping the centre of the image
+// when the aspect ratios differ.
+func Resize(img image.Image, size Size) image.Image {
+	src := img.Bounds()
+	crop := src
+	if src.Dx()*size.Height > src.Dy()*size.Width {
+		width := src.Dy() * size.Width / size.Height
+		crop.Min.X += (src.Dx() - width) / 2
+		crop.Max.X = crop.Min.X + width
+	} else {
+		height := src.Dx() * size.Height / size.Width
+		crop.Min.Y += (src.Dy() - height) / 2
+		crop.Max.Y = crop.Min.Y + height
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, size.Width, size.Height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Src, nil)
+	return dst
+}
+
+type Format struct {
+	Name      string
+	Ext       string
+	MediaType string
+}
+
+var (
+	JPEG = Format{Name: "jpeg", Ext: ".jpg", MediaType: "image/jpeg"}
+	WebP = Format{Name: "webp", Ext: ".webp", MediaType: "image/webp"}
+
+	Formats = []Format{JPEG, WebP}
+)
+
+// ParseFormat looks a format up by name; "jpg" is accepted for JPEG.
+func ParseFormat(name string) (Format, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "jpg" {
+		name = JPEG.Name
+	}
+	for _, format := range Formats {
+		if format.Name == name {
+			return format, nil
+		}
+	}
+	return Format{}, fmt.Errorf("unsupported thumbnail format %q", name)
+}
+
+const jpegQuality = 85
+
+// Encode writes img to w in format. The standard library has no WebP
+// encoder, so WebP goes through ffmpeg via t.
+func Encode(ctx context.Context, t media.Transcoder, w io.Writer, img image.Image, format Format) error {
+	switch format {
+	case JPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	case WebP:
+		return encodeWebP(ctx, t, w, img)
+	default:
+		return fmt.Errorf("unsupported thumbnail format %q", format.Name)
+	}
+}
+
+func encodeWebP(ctx context.Context, t media.Transcoder, w io.Writer, img image.Image) error {
+	var input bytes.Buffer
+	if err := png.Encode(&input, img); err != nil {
+		return err
+	}
+	args := []string{
+		"-v", "error",
+		"-f", "png_pipe", "-i", "pipe:0",
+		"-c:v", "libwebp", "-quality", "80",
+		"-f", "webp", "pipe:1",
+	}
+	if err := t.Run(ctx, args, &input, w); err != nil {
+		return fmt.Errorf("webp encoding failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package thumbnails
+
+import (
+	"image"
+	"slices"
+	"testing"
+)
+
+func TestSizesFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		bounds image.Rectangle
+		want   []Size
+	}{
+		{"full HD", image.Rect(0, 0, 1920, 1080), LandscapeSizes},
+		{"exactly 720p", image.Rect(0, 0, 1280, 720), LandscapeSizes},
+		{"wide but short", image.Rect(0, 0, 2560, 400), []Size{{640, 360}, {320, 180}}},
+		{"4:3", image.Rect(0, 0, 960, 720), []Size{{640, 360}, {320, 180}}},
+		{"tiny", image.Rect(0, 0, 100, 50), []Size{{320, 180}}},
+		{"portrait", image.Rect(0, 0, 1080, 1920), PortraitSizes},
+		{"tall but narrow", image.Rect(0, 0, 400, 2560), []Size{{360, 640}, {180, 320}}},
+		{"square", image.Rect(0, 0, 500, 500), []Size{{320, 180}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SizesFor(tt.bounds); !slices.Equal(got, tt.want) {
+				t.Errorf("SizesFor(%v) = %v, want %v", tt.bounds.Size(), got, tt.want)
+			}
+		})
+	}
+}
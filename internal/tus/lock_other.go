@@ -0,0 +1,11 @@
+//go:build !unix
+
+package tus
+
+import "os"
+
+// lockFile does nothing without flock: uploads are only locked within the
+// process, so replicas must not share the upload directory.
+func lockFile(f *os.File) error {
+	return nil
+}
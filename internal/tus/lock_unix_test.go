@@ -0,0 +1,30 @@
+//go:build unix
+
+package tus
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteChunkLockedByAnotherProcess(t *testing.T) {
+	store, upload := newUpload(t, 10)
+
+	// Another replica holding the file lock looks like this one.
+	f, err := os.OpenFile(store.DataPath(upload.ID), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.WriteChunk(upload.ID, 0, strings.NewReader("hello"), time.Now().Add(time.Hour))
+	if !errors.Is(err, ErrLocked) {
+		t.Errorf("WriteChunk returned %v, want ErrLocked", err)
+	}
+}
@@ -0,0 +1,363 @@
+package tus
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	Version    = "1.0.0"
+	Extensions = "creation,termination,expiration"
+)
+
+var (
+	ErrNotFound       = errors.New("upload not found")
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	ErrTooLarge       = errors.New("chunk exceeds upload length")
+	ErrLocked         = errors.New("upload is locked by another request")
+	ErrIncomplete     = errors.New("upload is not complete")
+)
+
+type Upload struct {
+	ID        string            `json:"id"`
+	VideoID   uuid.UUID         `json:"video_id"`
+	UserID    uuid.UUID         `json:"user_id"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+func (u Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// Store persists partial uploads on disk as a pair of files: <id>.bin holds
+// the bytes received so far and <id>.info the JSON encoded Upload. The size
+// of the .bin file is the source of truth for the offset so an interrupted
+// PATCH never loses bytes that already reached the disk.
+type Store struct {
+	dir   string
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{
+		dir:   dir,
+		locks: map[string]*sync.Mutex{},
+	}, nil
+}
+
+func (s *Store) DataPath(id string) string {
+	return filepath.Join(s.dir, id+".bin")
+}
+
+func (s *Store) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".info")
+}
+
+func (s *Store) Create(videoID, userID uuid.UUID, length int64, metadata map[string]string, expiresAt time.Time) (Upload, error) {
+	idBuf := make([]byte, 16)
+	if _, err := rand.Read(idBuf); err != nil {
+		return Upload{}, err
+	}
+	upload := Upload{
+		ID:        hex.EncodeToString(idBuf),
+		VideoID:   videoID,
+		UserID:    userID,
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+	f, err := os.OpenFile(s.DataPath(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return Upload{}, err
+	}
+	if err := f.Close(); err != nil {
+		return Upload{}, err
+	}
+	if err := s.writeInfo(upload); err != nil {
+		os.Remove(s.DataPath(upload.ID))
+		return Upload{}, err
+	}
+	return upload, nil
+}
+
+func (s *Store) Get(id string) (Upload, error) {
+	if !validID(id) {
+		return Upload{}, ErrNotFound
+	}
+	dat, err := os.ReadFile(s.infoPath(id))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Upload{}, ErrNotFound
+		}
+		return Upload{}, err
+	}
+	var upload Upload
+	if err := json.Unmarshal(dat, &upload); err != nil {
+		return Upload{}, err
+	}
+	stat, err := os.Stat(s.DataPath(id))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Upload{}, ErrNotFound
+		}
+		return Upload{}, err
+	}
+	upload.Offset = stat.Size()
+	return upload, nil
+}
+
+// WriteChunk appends the bytes from r at offset. Whatever was written before
+// r failed is kept, and the returned Upload reflects the new offset even
+// when err is non-nil. A chunk running past the upload's length is rolled
+// back entirely and ErrTooLarge returned, so the upload is never left full
+// without having been accepted.
+func (s *Store) WriteChunk(id string, offset int64, r io.Reader, expiresAt time.Time) (Upload, error) {
+	f, release, err := s.acquire(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	defer release()
+
+	upload, err := s.Get(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	if offset != upload.Offset {
+		return upload, ErrOffsetMismatch
+	}
+
+	remaining := upload.Length - upload.Offset
+	n, copyErr := io.Copy(f, io.LimitReader(r, remaining))
+	if copyErr == nil && n == remaining {
+		var probe [1]byte
+		if extra, _ := r.Read(probe[:]); extra > 0 {
+			if err := f.Truncate(upload.Offset); err != nil {
+				return upload, err
+			}
+			return upload, ErrTooLarge
+		}
+	}
+	upload.Offset += n
+
+	// Closing f releases the file lock, so the info goes first.
+	upload.ExpiresAt = expiresAt
+	if err := s.writeInfo(upload); err != nil {
+		return upload, err
+	}
+	if copyErr != nil {
+		return upload, copyErr
+	}
+	return upload, f.Close()
+}
+
+// Finish hands a complete upload to fn while holding its lock and removes
+// the upload once fn succeeds, so fn runs at most once for an upload that
+// several requests complete. If fn fails the upload is kept.
+func (s *Store) Finish(id string, fn func(upload Upload) error) error {
+	_, release, err := s.acquire(id)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	upload, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	if !upload.Complete() {
+		return ErrIncomplete
+	}
+	if err := fn(upload); err != nil {
+		return err
+	}
+	return s.remove(id)
+}
+
+// Terminate removes the upload. It returns ErrLocked while a chunk is being
+// written to it.
+func (s *Store) Terminate(id string) error {
+	_, release, err := s.acquire(id)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return s.remove(id)
+}
+
+// RemoveExpired terminates every upload whose expiry is before now and
+// returns how many were removed. Uploads that are locked are in use and
+// left alone.
+func (s *Store) RemoveExpired(now time.Time) (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".info")
+		if !ok {
+			continue
+		}
+		ok, err := s.removeExpired(id, now)
+		if errors.Is(err, ErrLocked) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// removeExpired removes the upload if it expired before now. An info file
+// whose data file is gone can never be resumed, so it is removed as well.
+func (s *Store) removeExpired(id string, now time.Time) (bool, error) {
+	_, release, err := s.acquire(id)
+	if errors.Is(err, ErrNotFound) {
+		err := os.Remove(s.infoPath(id))
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	upload, err := s.Get(id)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+	if err == nil && !upload.ExpiresAt.Before(now) {
+		return false, nil
+	}
+	return true, s.remove(id)
+}
+
+// acquire locks the upload against other requests, in this process and in
+// replicas sharing the directory, and opens its data file for appending.
+// release closes the file and unlocks the upload.
+func (s *Store) acquire(id string) (f *os.File, release func(), err error) {
+	if !validID(id) {
+		return nil, nil, ErrNotFound
+	}
+	lock := s.lock(id)
+	if !lock.TryLock() {
+		return nil, nil, ErrLocked
+	}
+	f, err = os.OpenFile(s.DataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// The upload is gone for good; don't keep a lock for it.
+			s.forget(id)
+			err = ErrNotFound
+		}
+		lock.Unlock()
+		return nil, nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		lock.Unlock()
+		return nil, nil, err
+	}
+	return f, func() {
+		f.Close()
+		lock.Unlock()
+	}, nil
+}
+
+// remove deletes an upload the caller has acquired.
+func (s *Store) remove(id string) error {
+	for _, p := range []string{s.DataPath(id), s.infoPath(id)} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	s.forget(id)
+	return nil
+}
+
+func (s *Store) writeInfo(upload Upload) error {
+	dat, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	tmp := s.infoPath(upload.ID) + ".tmp"
+	if err := os.WriteFile(tmp, dat, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.infoPath(upload.ID))
+}
+
+func (s *Store) lock(id string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, ok := s.locks[id]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.locks[id] = lock
+	}
+	return lock
+}
+
+func (s *Store) forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.locks, id)
+}
+
+func validID(id string) bool {
+	_, err := hex.DecodeString(id)
+	return err == nil && len(id) == 32
+}
+
+// ParseMetadata decodes an Upload-Metadata header: comma separated pairs of
+// a key and an optional base64 encoded value.
+func ParseMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid metadata value for %q: %w", fields[0], err)
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("invalid metadata pair %q", pair)
+		}
+	}
+	return metadata, nil
+}
@@ -0,0 +1,175 @@
+package tus
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newUpload(t *testing.T, length int64) (*Store, Upload) {
+	t.Helper()
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := store.Create(uuid.New(), uuid.New(), length, nil, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, upload
+}
+
+func TestWriteChunk(t *testing.T) {
+	store, upload := newUpload(t, 10)
+	expires := time.Now().Add(time.Hour)
+
+	upload, err := store.WriteChunk(upload.ID, 0, strings.NewReader("hello"), expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.Offset != 5 || upload.Complete() {
+		t.Fatalf("after first chunk: offset %d, complete %v", upload.Offset, upload.Complete())
+	}
+
+	_, err = store.WriteChunk(upload.ID, 0, strings.NewReader("hello"), expires)
+	if !errors.Is(err, ErrOffsetMismatch) {
+		t.Errorf("stale offset returned %v, want ErrOffsetMismatch", err)
+	}
+
+	upload, err = store.WriteChunk(upload.ID, 5, strings.NewReader("world"), expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !upload.Complete() {
+		t.Fatalf("upload not complete at offset %d", upload.Offset)
+	}
+	data, err := os.ReadFile(store.DataPath(upload.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "helloworld" {
+		t.Errorf("data = %q", data)
+	}
+}
+
+// A body without a Content-Length can only be caught running past the
+// length while it is written.
+func TestWriteChunkTooLargeRollsBack(t *testing.T) {
+	store, upload := newUpload(t, 10)
+	expires := time.Now().Add(time.Hour)
+
+	upload, err := store.WriteChunk(upload.ID, 0, strings.NewReader("hello"), expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err = store.WriteChunk(upload.ID, 5, io.MultiReader(strings.NewReader("world"), strings.NewReader("!")), expires)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("overflowing chunk returned %v, want ErrTooLarge", err)
+	}
+	if upload.Offset != 5 {
+		t.Errorf("returned offset %d, want 5", upload.Offset)
+	}
+
+	upload, err = store.Get(upload.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.Offset != 5 || upload.Complete() {
+		t.Errorf("stored offset %d after rejected chunk, want 5", upload.Offset)
+	}
+
+	upload, err = store.WriteChunk(upload.ID, 5, strings.NewReader("world"), expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !upload.Complete() {
+		t.Errorf("retried chunk left offset %d", upload.Offset)
+	}
+}
+
+func TestTerminate(t *testing.T) {
+	store, upload := newUpload(t, 10)
+	if _, err := store.WriteChunk(upload.ID, 0, strings.NewReader("hello"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Terminate(upload.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(upload.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Terminate returned %v, want ErrNotFound", err)
+	}
+	if err := store.Terminate(upload.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Terminate returned %v, want ErrNotFound", err)
+	}
+	if len(store.locks) != 0 {
+		t.Errorf("%d locks left after Terminate", len(store.locks))
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	store, upload := newUpload(t, 10)
+	busy, err := store.Create(uuid.New(), uuid.New(), 10, nil, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := store.lock(busy.ID)
+	lock.Lock()
+
+	removed, err := store.RemoveExpired(time.Now().Add(2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("removed %d uploads, want 1", removed)
+	}
+	if _, err := store.Get(upload.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired upload is still there (%v)", err)
+	}
+	if _, err := store.Get(busy.ID); err != nil {
+		t.Errorf("locked upload was removed (%v)", err)
+	}
+	lock.Unlock()
+}
+
+func TestFinish(t *testing.T) {
+	store, upload := newUpload(t, 5)
+	finish := func(upload Upload) error {
+		t.Errorf("finished an incomplete upload")
+		return nil
+	}
+	if err := store.Finish(upload.ID, finish); !errors.Is(err, ErrIncomplete) {
+		t.Errorf("Finish on an incomplete upload returned %v, want ErrIncomplete", err)
+	}
+	if _, err := store.WriteChunk(upload.ID, 0, strings.NewReader("hello"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("queue is down")
+	err := store.Finish(upload.ID, func(Upload) error { return failed })
+	if !errors.Is(err, failed) {
+		t.Fatalf("Finish returned %v, want %v", err, failed)
+	}
+	if _, err := store.Get(upload.ID); err != nil {
+		t.Errorf("upload was removed after a failed finish (%v)", err)
+	}
+
+	calls := 0
+	finish = func(Upload) error {
+		calls++
+		return nil
+	}
+	if err := store.Finish(upload.ID, finish); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Finish(upload.ID, finish); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Finish returned %v, want ErrNotFound", err)
+	}
+	if calls != 1 {
+		t.Errorf("finished %d times, want once", calls)
+	}
+}
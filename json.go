@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
@@ -32,3 +35,16 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.WriteHeader(code)
 	w.Write(dat)
 }
+
+// respondWithDBError responds to an error from the database package: 404 for
+// a missing record, 409 for a conflicting one and 500 for anything else.
+func respondWithDBError(w http.ResponseWriter, msg string, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, database.ErrConflict):
+		code = http.StatusConflict
+	}
+	respondWithError(w, code, msg, err)
+}
@@ -7,25 +7,63 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/thumbnails"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tus"
 	"github.com/google/uuid"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	db               database.Client
+	users            database.UserStore
+	videos           database.VideoStore
+	refreshTokens    database.RefreshTokenStore
+	blobs            database.BlobStore
+	versions         database.VersionStore
+	processingJobs   database.ProcessingJobStore
+	directUploads    database.DirectUploadStore
+	cleanupTasks     database.CleanupTaskStore
+	videoDeleter     database.VideoDeleter
+	resetDatabase    func() error
 	jwtSecret        string
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
+	assetsBaseURL    string
 	s3Bucket         string
 	s3Region         string
 	s3CfDistribution string
 	port             string
 	s3Client         *s3.Client
+	s3Options        storage.S3Options
+	videoStore       storage.BlobStore
+	thumbnailStore   storage.BlobStore
+	tusStore         *tus.Store
+	tusExpiry        time.Duration
+	directUploadTTL  time.Duration
+	prober           media.Prober
+	transcoder       media.Transcoder
+
+	processingSpoolDir  string
+	processingWake      chan struct{}
+	cleanupWake         chan struct{}
+	streamingFormats    streamingFormats
+	streamingRenditions []streamingRendition
+	thumbnailAuto       bool
+	thumbnailFormat     thumbnails.Format
+	thumbnailOffset     time.Duration
+	spriteInterval      time.Duration
+	spriteGrid          spriteGrid
+	spriteTileWidth     int
+	videoLimits         videoLimits
+	encodeSettings      encodeSettings
+	versionRetention    time.Duration
+	versionLimit        int
 }
 
 type thumbnail struct {
@@ -47,6 +85,10 @@ func main() {
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrateCommand(db, os.Args[2:]))
+	}
+	migrateOnStart(db, envOrDefault("DB_MIGRATE_ON_START", "true") == "true")
 
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
@@ -68,41 +110,218 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	port := os.Getenv("PORT")
+	if port == "" {
+		log.Fatal("PORT environment variable is not set")
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	// Where clients reach ASSETS_ROOT, for the local storage backend. Set it
+	// when the server is behind a proxy or on another host.
+	assetsBaseURL := envOrDefault("ASSETS_BASE_URL", "http://localhost:"+port+"/assets")
+
+	videoStorage := envOrDefault("VIDEO_STORAGE", storageBackendS3)
+	thumbnailStorage := envOrDefault("THUMBNAIL_STORAGE", storageBackendLocal)
+
+	cfg := apiConfig{
+		users:          db,
+		videos:         db,
+		refreshTokens:  db,
+		blobs:          db,
+		versions:       db,
+		processingJobs: db,
+		directUploads:  db,
+		cleanupTasks:   db,
+		videoDeleter:   db,
+		resetDatabase:  db.Reset,
+		jwtSecret:      jwtSecret,
+		platform:       platform,
+		filepathRoot:   filepathRoot,
+		assetsRoot:     assetsRoot,
+		assetsBaseURL:  assetsBaseURL,
+		port:           port,
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	if videoStorage == storageBackendS3 || thumbnailStorage == storageBackendS3 {
+		cfg.s3Bucket = os.Getenv("S3_BUCKET")
+		if cfg.s3Bucket == "" {
+			log.Fatal("S3_BUCKET environment variable is not set")
+		}
+
+		cfg.s3Region = os.Getenv("S3_REGION")
+		if cfg.s3Region == "" {
+			log.Fatal("S3_REGION environment variable is not set")
+		}
+
+		cfg.s3CfDistribution = os.Getenv("S3_CF_DISTRO")
+		if cfg.s3CfDistribution == "" {
+			log.Fatal("S3_CF_DISTRO environment variable is not set")
+		}
+
+		s3Config, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(cfg.s3Region))
+		if err != nil {
+			log.Fatalf("Couldn't load SDK config: %v", err)
+		}
+		cfg.s3Client = s3.NewFromConfig(s3Config)
+
+		partSizeMB, err := envInt("S3_PART_SIZE_MB", 16)
+		if err != nil {
+			log.Fatalf("Invalid S3_PART_SIZE_MB: %v", err)
+		}
+		if partSizeMB < 5 {
+			log.Fatal("S3_PART_SIZE_MB must be at least 5")
+		}
+		cfg.s3Options.PartSize = int64(partSizeMB) << 20
+		cfg.s3Options.Concurrency, err = envInt("S3_UPLOAD_CONCURRENCY", 4)
+		if err != nil {
+			log.Fatalf("Invalid S3_UPLOAD_CONCURRENCY: %v", err)
+		}
+		cfg.s3Options.MaxRetries, err = envInt("S3_PART_MAX_RETRIES", 3)
+		if err != nil {
+			log.Fatalf("Invalid S3_PART_MAX_RETRIES: %v", err)
+		}
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	cfg.videoStore, err = cfg.newBlobStore(videoStorage)
+	if err != nil {
+		log.Fatalf("Couldn't create video storage: %v", err)
 	}
-	s3Config, err := config.LoadDefaultConfig(context.Background())
+	cfg.thumbnailStore, err = cfg.newBlobStore(thumbnailStorage)
 	if err != nil {
-		log.Fatalf("Couldn't load SDK config: %v", err)
+		log.Fatalf("Couldn't create thumbnail storage: %v", err)
 	}
-	s3Client := s3.NewFromConfig(s3Config)
-	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
-		s3Client:         s3Client,
+
+	gcGrace, err := envDuration("GC_GRACE_PERIOD", 24*time.Hour)
+	if err != nil {
+		log.Fatalf("Invalid GC_GRACE_PERIOD: %v", err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		os.Exit(cfg.runGCCommand(os.Args[2:], gcGrace))
+	}
+
+	cfg.tusStore, err = tus.NewStore(envOrDefault("TUS_UPLOAD_DIR", "tus_uploads"))
+	if err != nil {
+		log.Fatalf("Couldn't create tus upload directory: %v", err)
+	}
+	cfg.tusExpiry, err = envDuration("TUS_UPLOAD_EXPIRY", 24*time.Hour)
+	if err != nil {
+		log.Fatalf("Invalid TUS_UPLOAD_EXPIRY: %v", err)
+	}
+
+	cfg.directUploadTTL, err = envDuration("DIRECT_UPLOAD_URL_TTL", time.Hour)
+	if err != nil {
+		log.Fatalf("Invalid DIRECT_UPLOAD_URL_TTL: %v", err)
+	}
+
+	cfg.versionRetention, err = envDuration("VIDEO_VERSION_RETENTION", 7*24*time.Hour)
+	if err != nil {
+		log.Fatalf("Invalid VIDEO_VERSION_RETENTION: %v", err)
+	}
+	cfg.versionLimit, err = envInt("VIDEO_VERSION_LIMIT", 10)
+	if err != nil || cfg.versionLimit < 0 {
+		log.Fatalf("Invalid VIDEO_VERSION_LIMIT: %v", err)
+	}
+
+	cfg.processingSpoolDir = envOrDefault("PROCESSING_SPOOL_DIR", "processing_spool")
+	err = os.MkdirAll(cfg.processingSpoolDir, 0755)
+	if err != nil {
+		log.Fatalf("Couldn't create processing spool directory: %v", err)
+	}
+	cfg.processingWake = make(chan struct{}, 1)
+	cfg.cleanupWake = make(chan struct{}, 1)
+	processingWorkers, err := envInt("PROCESSING_WORKERS", 2)
+	if err != nil {
+		log.Fatalf("Invalid PROCESSING_WORKERS: %v", err)
+	}
+
+	probeTimeout, err := envDuration("MEDIA_PROBE_TIMEOUT", 30*time.Second)
+	if err != nil {
+		log.Fatalf("Invalid MEDIA_PROBE_TIMEOUT: %v", err)
+	}
+	transcodeTimeout, err := envDuration("MEDIA_TRANSCODE_TIMEOUT", 2*time.Hour)
+	if err != nil {
+		log.Fatalf("Invalid MEDIA_TRANSCODE_TIMEOUT: %v", err)
+	}
+	mediaExec := media.NewExec(probeTimeout, transcodeTimeout)
+	cfg.prober = mediaExec
+	cfg.transcoder = mediaExec
+
+	cfg.streamingFormats, err = parseStreamingFormats(envOrDefault("STREAMING_FORMATS", "hls"))
+	if err != nil {
+		log.Fatalf("Invalid STREAMING_FORMATS: %v", err)
+	}
+	cfg.streamingRenditions, err = parseRenditions(envOrDefault("STREAMING_RENDITIONS", "1080,720,480,360"))
+	if err != nil {
+		log.Fatalf("Invalid STREAMING_RENDITIONS: %v", err)
+	}
+
+	cfg.thumbnailAuto = envOrDefault("THUMBNAIL_AUTO", "true") == "true"
+	cfg.thumbnailFormat, err = thumbnails.ParseFormat(envOrDefault("THUMBNAIL_FORMAT", "jpeg"))
+	if err != nil {
+		log.Fatalf("Invalid THUMBNAIL_FORMAT: %v", err)
+	}
+	cfg.thumbnailOffset, err = envDuration("THUMBNAIL_OFFSET", 5*time.Second)
+	if err != nil {
+		log.Fatalf("Invalid THUMBNAIL_OFFSET: %v", err)
+	}
+
+	if envOrDefault("SPRITES_ENABLED", "true") == "true" {
+		cfg.spriteInterval, err = envDuration("SPRITE_INTERVAL", 5*time.Second)
+		if err != nil {
+			log.Fatalf("Invalid SPRITE_INTERVAL: %v", err)
+		}
+		if cfg.spriteInterval <= 0 {
+			log.Fatal("Invalid SPRITE_INTERVAL: must be positive")
+		}
+		cfg.spriteGrid, err = parseSpriteGrid(envOrDefault("SPRITE_GRID", "10x10"))
+		if err != nil {
+			log.Fatalf("Invalid SPRITE_GRID: %v", err)
+		}
+		cfg.spriteTileWidth, err = envInt("SPRITE_TILE_WIDTH", 160)
+		if err != nil || cfg.spriteTileWidth < 16 || cfg.spriteTileWidth%2 != 0 {
+			log.Fatalf("Invalid SPRITE_TILE_WIDTH: must be an even number of at least 16 pixels")
+		}
+	}
+
+	cfg.videoLimits.MaxDuration, err = envDuration("VIDEO_MAX_DURATION", 4*time.Hour)
+	if err != nil {
+		log.Fatalf("Invalid VIDEO_MAX_DURATION: %v", err)
+	}
+	if maxResolution := envOrDefault("VIDEO_MAX_RESOLUTION", "3840x2160"); maxResolution != "0" {
+		width, height, err := parseResolution(maxResolution)
+		if err != nil {
+			log.Fatalf("Invalid VIDEO_MAX_RESOLUTION: %v", err)
+		}
+		cfg.videoLimits.MaxLongSide, cfg.videoLimits.MaxShortSide = max(width, height), min(width, height)
+	}
+	maxBitRate, err := envInt("VIDEO_MAX_BITRATE", 100000)
+	if err != nil {
+		log.Fatalf("Invalid VIDEO_MAX_BITRATE: %v", err)
+	}
+	cfg.videoLimits.MaxBitRate = int64(maxBitRate) * 1000
+	cfg.videoLimits.Containers = parseContainers(envOrDefault("VIDEO_CONTAINERS", "mp4,mov,webm,mkv"))
+
+	cfg.encodeSettings.Mode, err = parseTranscodeMode(envOrDefault("TRANSCODE_MODE", "auto"))
+	if err != nil {
+		log.Fatalf("Invalid TRANSCODE_MODE: %v", err)
+	}
+	cfg.encodeSettings.CRF, err = envInt("TRANSCODE_CRF", 23)
+	if err != nil || cfg.encodeSettings.CRF < 0 || cfg.encodeSettings.CRF > 51 {
+		log.Fatal("Invalid TRANSCODE_CRF: must be between 0 and 51")
+	}
+	cfg.encodeSettings.Preset = envOrDefault("TRANSCODE_PRESET", "medium")
+	if !x264Presets[cfg.encodeSettings.Preset] {
+		log.Fatalf("Invalid TRANSCODE_PRESET: unknown x264 preset %q", cfg.encodeSettings.Preset)
+	}
+	if maxResolution := envOrDefault("TRANSCODE_MAX_RESOLUTION", "1920x1080"); maxResolution != "0" {
+		width, height, err := parseResolution(maxResolution)
+		if err != nil {
+			log.Fatalf("Invalid TRANSCODE_MAX_RESOLUTION: %v", err)
+		}
+		cfg.encodeSettings.MaxLongSide, cfg.encodeSettings.MaxShortSide = max(width, height), min(width, height)
+	}
+	cfg.encodeSettings.AudioBitrate, err = envInt("TRANSCODE_AUDIO_BITRATE", 160)
+	if err != nil || cfg.encodeSettings.AudioBitrate <= 0 {
+		log.Fatal("Invalid TRANSCODE_AUDIO_BITRATE: must be a positive number of kbit/s")
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -126,24 +345,65 @@ func main() {
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
 	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
 	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
+	mux.HandleFunc("POST /api/video_upload/{videoID}/presign", cfg.handlerPresignVideoUpload)
+	mux.HandleFunc("POST /api/video_upload/{videoID}/complete", cfg.handlerCompleteVideoUpload)
+	mux.HandleFunc("POST /api/video_upload/{videoID}/precheck", cfg.handlerVideoPrecheck)
+	mux.HandleFunc("OPTIONS /api/tus/{videoID}", cfg.handlerTusOptions)
+	mux.HandleFunc("POST /api/tus/{videoID}", cfg.handlerTusCreate)
+	mux.HandleFunc("HEAD /api/tus/{videoID}/{uploadID}", cfg.handlerTusHead)
+	mux.HandleFunc("PATCH /api/tus/{videoID}/{uploadID}", cfg.handlerTusPatch)
+	mux.HandleFunc("DELETE /api/tus/{videoID}/{uploadID}", cfg.handlerTusDelete)
 	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/processing", cfg.handlerVideoProcessingGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/versions", cfg.handlerVideoVersionsGet)
+	mux.HandleFunc("POST /api/videos/{videoID}/versions/{versionID}/promote", cfg.handlerVideoVersionPromote)
+	mux.HandleFunc("POST /api/videos/{videoID}/thumbnail/regenerate", cfg.handlerRegenerateThumbnail)
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
 
+	err = cfg.recoverProcessingJobs()
+	if err != nil {
+		log.Fatalf("Couldn't recover processing jobs: %v", err)
+	}
+	cfg.startProcessingWorkers(processingWorkers)
+
+	go cfg.sweepExpiredTusUploads(time.Hour)
+	go cfg.cleanupWorker()
+	gcInterval, err := envDuration("GC_INTERVAL", 0)
+	if err != nil {
+		log.Fatalf("Invalid GC_INTERVAL: %v", err)
+	}
+	if gcInterval > 0 {
+		go cfg.scheduleGC(gcInterval, gcGrace, envOrDefault("GC_DRY_RUN", "false") == "true")
+	}
+	if sweeper, ok := cfg.videoStore.(multipartSweeper); ok {
+		sweepInterval, err := envDuration("S3_MULTIPART_SWEEP_INTERVAL", time.Hour)
+		if err != nil {
+			log.Fatalf("Invalid S3_MULTIPART_SWEEP_INTERVAL: %v", err)
+		}
+		maxAge, err := envDuration("S3_MULTIPART_MAX_AGE", 24*time.Hour)
+		if err != nil {
+			log.Fatalf("Invalid S3_MULTIPART_MAX_AGE: %v", err)
+		}
+		go cfg.sweepStaleMultipartUploads(sweeper, sweepInterval, maxAge)
+	}
+
 	srv := &http.Server{
 		Addr:    ":" + port,
 		Handler: mux,
 	}
 
 	log.Printf("Serving on: http://localhost:%s/app/\n", port)
-	lstOutputs, err := cfg.s3Client.ListBuckets(context.Background(), &s3.ListBucketsInput{})
-	if err != nil {
-		log.Fatalf("Couldn't list buckets: %v", err)
-	}
-	for _, bucket := range lstOutputs.Buckets {
-		log.Printf("Bucket: %s", *bucket.Name)
+	if cfg.s3Client != nil {
+		lstOutputs, err := cfg.s3Client.ListBuckets(context.Background(), &s3.ListBucketsInput{})
+		if err != nil {
+			log.Fatalf("Couldn't list buckets: %v", err)
+		}
+		for _, bucket := range lstOutputs.Buckets {
+			log.Printf("Bucket: %s", *bucket.Name)
+		}
 	}
 	log.Fatal(srv.ListenAndServe())
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// newTestConfig returns an apiConfig backed by a fresh SQLite file and
+// in-memory storage, with fake standing in for ffprobe and ffmpeg.
+func newTestConfig(t *testing.T, fake *media.Fake) *apiConfig {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.NewClient(filepath.Join(dir, "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	return &apiConfig{
+		users:              db,
+		videos:             db,
+		refreshTokens:      db,
+		blobs:              db,
+		versions:           db,
+		processingJobs:     db,
+		directUploads:      db,
+		cleanupTasks:       db,
+		videoDeleter:       db,
+		jwtSecret:          "test-secret",
+		videoStore:         storage.NewMemoryStore("https://cdn.example.com"),
+		thumbnailStore:     storage.NewMemoryStore("https://cdn.example.com"),
+		prober:             fake,
+		transcoder:         fake,
+		processingSpoolDir: dir,
+		processingWake:     make(chan struct{}, 1),
+		cleanupWake:        make(chan struct{}, 1),
+		videoLimits:        videoLimits{Containers: map[string]bool{"mp4": true}},
+		encodeSettings:     encodeSettings{Mode: transcodeAuto, CRF: 23, Preset: "medium", AudioBitrate: 160},
+		versionRetention:   time.Hour,
+		versionLimit:       5,
+	}
+}
+
+// newTestVideo creates a new user and an empty video owned by them.
+func newTestVideo(t *testing.T, cfg *apiConfig) database.Video {
+	t.Helper()
+	email := uuid.NewString() + "@example.com"
+	user, err := cfg.users.CreateUser(database.CreateUserParams{Email: email, Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := cfg.videos.CreateVideo(database.CreateVideoParams{Title: "Test", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return video
+}
+
+// newMemoryTestConfig returns an apiConfig whose users, videos and refresh
+// tokens live in a MemoryStore, for handlers that need nothing else.
+func newMemoryTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	store := database.NewMemoryStore()
+	return &apiConfig{
+		users:         store,
+		videos:        store,
+		refreshTokens: store,
+		jwtSecret:     "test-secret",
+	}
+}
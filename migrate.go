@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// migrateOnStart brings the schema up to date before the server starts. With
+// autoMigrate off it only checks that nothing is pending, for deployments
+// that run "tubely migrate up" as a separate step.
+func migrateOnStart(db database.Client, autoMigrate bool) {
+	if autoMigrate {
+		applied, err := db.MigrateUp()
+		if err != nil {
+			log.Fatalf("Couldn't migrate database: %v", err)
+		}
+		for _, m := range applied {
+			log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+		}
+		return
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		log.Fatalf("Couldn't get database schema version: %v", err)
+	}
+	latest := database.LatestSchemaVersion()
+	if version > latest {
+		log.Fatalf("%v: database is at version %d, this build knows up to %d", database.ErrSchemaTooNew, version, latest)
+	}
+	if version < latest {
+		log.Fatalf("Database is at version %d but this build needs %d; run \"tubely migrate up\"", version, latest)
+	}
+}
+
+// runMigrateCommand implements "tubely migrate up|down|status". It returns
+// the process exit code.
+func runMigrateCommand(db database.Client, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: tubely migrate up|down|status")
+		return 2
+	}
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp()
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("already up to date")
+		}
+	case "down":
+		m, ok, err := db.MigrateDown()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+			return 1
+		}
+		if !ok {
+			fmt.Println("nothing to revert")
+			return 0
+		}
+		fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+	case "status":
+		statuses, err := db.MigrationStatus()
+		if err == nil {
+			var version int
+			version, err = db.SchemaVersion()
+			if version > database.LatestSchemaVersion() {
+				err = fmt.Errorf("%w: database is at version %d", database.ErrSchemaTooNew, version)
+			}
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, applied)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+			return 1
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "migrate: unknown command %q\n", args[0])
+		return 2
+	}
+	return 0
+}
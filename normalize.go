@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+// transcodeMode controls when uploads are re-encoded rather than remuxed.
+type transcodeMode string
+
+const (
+	// transcodeAuto re-encodes only streams browsers can't play.
+	transcodeAuto transcodeMode = "auto"
+	// transcodeAlways re-encodes every upload with the configured settings.
+	transcodeAlways transcodeMode = "always"
+	// transcodeNever only remuxes into MP4, whatever the codecs.
+	transcodeNever transcodeMode = "never"
+)
+
+func parseTranscodeMode(spec string) (transcodeMode, error) {
+	switch mode := transcodeMode(strings.ToLower(strings.TrimSpace(spec))); mode {
+	case transcodeAuto, transcodeAlways, transcodeNever:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown transcode mode %q, want auto, always or never", spec)
+	}
+}
+
+var x264Presets = map[string]bool{
+	"ultrafast": true,
+	"superfast": true,
+	"veryfast":  true,
+	"faster":    true,
+	"fast":      true,
+	"medium":    true,
+	"slow":      true,
+	"slower":    true,
+	"veryslow":  true,
+}
+
+// encodeSettings are the libx264 options used when an upload is re-encoded.
+// MaxLongSide and MaxShortSide bound the output size, swapped for portrait
+// videos; zero keeps the source size.
+type encodeSettings struct {
+	Mode         transcodeMode
+	CRF          int
+	Preset       string
+	MaxLongSide  int
+	MaxShortSide int
+	AudioBitrate int // kbit/s
+}
+
+// normalizePlan is what normalizeVideo does with each stream of a source.
+// AudioIndex is -1 for silent videos.
+type normalizePlan struct {
+	VideoIndex  int
+	AudioIndex  int
+	CopyVideo   bool
+	CopyAudio   bool
+	ScaleWidth  int
+	ScaleHeight int
+}
+
+// Transcoded reports whether any stream gets re-encoded.
+func (p normalizePlan) Transcoded() bool {
+	return !p.CopyVideo || (p.AudioIndex >= 0 && !p.CopyAudio)
+}
+
+// h264Profiles are the profiles every browser that plays H.264 decodes.
+var h264Profiles = map[string]bool{
+	"Constrained Baseline": true,
+	"Baseline":             true,
+	"Main":                 true,
+	"High":                 true,
+}
+
+// planNormalize decides per stream whether the source can be copied into an
+// MP4 as-is. Video can be copied when it is 8-bit 4:2:0 H.264 no larger than
+// the configured maximum, audio when it is AAC-LC.
+func planNormalize(videoMetaData media.Metadata, settings encodeSettings) (normalizePlan, error) {
+	index, ok := primaryVideoStream(videoMetaData)
+	if !ok {
+		return normalizePlan{}, errNoVideoStream
+	}
+	plan := normalizePlan{VideoIndex: index, AudioIndex: -1}
+	for i, stream := range videoMetaData.Streams {
+		if stream.CodecType == "audio" {
+			plan.AudioIndex = i
+			break
+		}
+	}
+
+	width, height := displaySize(videoMetaData, index)
+	plan.ScaleWidth, plan.ScaleHeight = fitWithin(width, height, settings.MaxLongSide, settings.MaxShortSide)
+
+	if settings.Mode == transcodeNever {
+		plan.CopyVideo, plan.CopyAudio = true, true
+		return plan, nil
+	}
+	if settings.Mode == transcodeAlways {
+		return plan, nil
+	}
+	video := videoMetaData.Streams[index]
+	plan.CopyVideo = video.CodecName == "h264" &&
+		h264Profiles[video.Profile] &&
+		(video.PixFmt == "yuv420p" || video.PixFmt == "yuvj420p") &&
+		plan.ScaleWidth == width && plan.ScaleHeight == height
+	if plan.AudioIndex >= 0 {
+		audio := videoMetaData.Streams[plan.AudioIndex]
+		plan.CopyAudio = audio.CodecName == "aac" && (audio.Profile == "" || audio.Profile == "LC")
+	}
+	return plan, nil
+}
+
+// fitWithin scales width x height down to fit the limits, keeping the aspect
+// ratio and rounding to the even sizes libx264 requires.
+func fitWithin(width, height, maxLongSide, maxShortSide int) (int, int) {
+	scale := 1.0
+	longSide, shortSide := max(width, height), min(width, height)
+	if maxLongSide > 0 && longSide > maxLongSide {
+		scale = min(scale, float64(maxLongSide)/float64(longSide))
+	}
+	if maxShortSide > 0 && shortSide > maxShortSide {
+		scale = min(scale, float64(maxShortSide)/float64(shortSide))
+	}
+	if scale == 1 {
+		return width, height
+	}
+	return max(2, int(float64(width)*scale)&^1), max(2, int(float64(height)*scale)&^1)
+}
+
+// normalizeArgs builds the ffmpeg command that writes plan's streams of
+// input to a faststart MP4 at output.
+func normalizeArgs(input, output string, plan normalizePlan, settings encodeSettings) []string {
+	args := []string{"-v", "error", "-y", "-i", input, "-map", fmt.Sprintf("0:%d", plan.VideoIndex)}
+	if plan.AudioIndex >= 0 {
+		args = append(args, "-map", fmt.Sprintf("0:%d", plan.AudioIndex))
+	}
+	if plan.CopyVideo {
+		args = append(args, "-c:v", "copy")
+	} else {
+		// Decoding applies the rotation, so the scale target is the display
+		// size and the output needs no rotation metadata.
+		args = append(args,
+			"-vf", fmt.Sprintf("scale=%d:%d,setsar=1", plan.ScaleWidth, plan.ScaleHeight),
+			"-c:v", "libx264",
+			"-profile:v", "high",
+			"-pix_fmt", "yuv420p",
+			"-preset", settings.Preset,
+			"-crf", strconv.Itoa(settings.CRF),
+		)
+	}
+	if plan.AudioIndex >= 0 {
+		if plan.CopyAudio {
+			args = append(args, "-c:a", "copy")
+		} else {
+			args = append(args, "-c:a", "aac", "-b:a", fmt.Sprintf("%dk", settings.AudioBitrate))
+		}
+	}
+	return append(args, "-movflags", "+faststart", "-f", "mp4", output)
+}
+
+// normalizeVideo writes the source as a browser-playable, faststart MP4
+// next to it and returns its path. Streams that are already compatible are
+// copied rather than re-encoded.
+func (cfg *apiConfig) normalizeVideo(ctx context.Context, filePath string, videoMetaData media.Metadata) (string, normalizePlan, error) {
+	plan, err := planNormalize(videoMetaData, cfg.encodeSettings)
+	if err != nil {
+		return "", normalizePlan{}, err
+	}
+	outputPath := filePath + ".processing"
+	err = cfg.transcoder.Run(ctx, normalizeArgs(filePath, outputPath, plan, cfg.encodeSettings), nil, nil)
+	if err != nil {
+		return "", normalizePlan{}, err
+	}
+	return outputPath, plan, nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxProcessingAttempts = 3
+	processingPollEvery   = 5 * time.Second
+	// A worker extends the lease on its job every processingHeartbeat. A
+	// job whose lease has run out belongs to a worker that died, and any
+	// replica's recovery takes it over.
+	processingLease     = 2 * time.Minute
+	processingHeartbeat = processingLease / 4
+)
+
+// errVideoDeleted fails a job for good: there is nothing to retry it for.
+var errVideoDeleted = errors.New("video was deleted before processing")
+
+// enqueueProcessing records a durable job for the upload at sourcePath, which
+// must already live in cfg.processingSpoolDir, and wakes an idle worker.
+func (cfg *apiConfig) enqueueProcessing(videoID uuid.UUID, sourcePath string) (database.ProcessingJob, error) {
+	job, err := cfg.processingJobs.CreateProcessingJob(videoID, sourcePath)
+	if err != nil {
+		return database.ProcessingJob{}, err
+	}
+	cfg.wakeProcessing()
+	return job, nil
+}
+
+func (cfg *apiConfig) wakeProcessing() {
+	select {
+	case cfg.processingWake <- struct{}{}:
+	default:
+	}
+}
+
+// spoolUpload moves a finished upload into the processing spool so it
+// survives until its job has run, even across restarts.
+func (cfg *apiConfig) spoolUpload(srcPath string) (string, error) {
+	dstPath := filepath.Join(cfg.processingSpoolDir, "upload-"+uuid.NewString()+".mp4")
+	if err := os.Rename(srcPath, dstPath); err == nil {
+		return dstPath, nil
+	}
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(dstPath)
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(dstPath)
+		return "", err
+	}
+	os.Remove(srcPath)
+	return dstPath, nil
+}
+
+func (cfg *apiConfig) startProcessingWorkers(n int) {
+	for range n {
+		go cfg.processingWorker()
+	}
+	go cfg.processingRecoveryLoop()
+}
+
+func (cfg *apiConfig) processingRecoveryLoop() {
+	ticker := time.NewTicker(processingLease)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := cfg.recoverProcessingJobs(); err != nil {
+			log.Printf("Error recovering processing jobs: %v", err)
+		}
+	}
+}
+
+func (cfg *apiConfig) processingWorker() {
+	ticker := time.NewTicker(processingPollEvery)
+	defer ticker.Stop()
+	for {
+		job, ok, err := cfg.processingJobs.ClaimProcessingJob(time.Now().UTC().Add(processingLease))
+		if err != nil {
+			log.Printf("Error claiming processing job: %v", err)
+		}
+		if ok {
+			cfg.runProcessingJob(job)
+			continue
+		}
+		select {
+		case <-cfg.processingWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// runProcessingJob runs a claimed job. A failed job is queued again, its
+// source kept for the retry, until it has used up maxProcessingAttempts.
+// A worker that loses its lease stops and leaves the job, source and all,
+// to whoever holds the lease now.
+func (cfg *apiConfig) runProcessingJob(job database.ProcessingJob) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := cfg.holdProcessingLease(job, cancel)
+	err := cfg.processJob(ctx, job)
+	stop()
+	if ctx.Err() != nil {
+		log.Printf("Lost the lease on processing job %v for video %v, stopped", job.ID, job.VideoID)
+		return
+	}
+
+	var msg *string
+	state := database.ProcessingStateReady
+	if err != nil {
+		errMsg := err.Error()
+		msg = &errMsg
+		if job.Attempts < maxProcessingAttempts && !errors.Is(err, errVideoDeleted) {
+			log.Printf("Processing job %v for video %v failed on attempt %d, retrying: %v", job.ID, job.VideoID, job.Attempts, err)
+			state = database.ProcessingStateQueued
+		} else {
+			log.Printf("Processing job %v for video %v failed: %v", job.ID, job.VideoID, err)
+			state = database.ProcessingStateFailed
+		}
+	}
+	err = cfg.processingJobs.UpdateProcessingJobState(job.ID, job.Lease, state, msg)
+	if errors.Is(err, database.ErrNotFound) {
+		log.Printf("Lost the lease on processing job %v for video %v before recording it %s", job.ID, job.VideoID, state)
+		return
+	}
+	if err != nil {
+		// The source stays for recovery to queue the job again.
+		log.Printf("Error updating processing job %v: %v", job.ID, err)
+		return
+	}
+	if state.Finished() {
+		os.Remove(job.SourcePath)
+	}
+}
+
+// holdProcessingLease extends the lease on job until the returned function
+// is called. If the lease has been lost, it calls lost and stops.
+func (cfg *apiConfig) holdProcessingLease(job database.ProcessingJob, lost func()) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(processingHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			err := cfg.processingJobs.ExtendProcessingJobLease(job.ID, job.Lease, time.Now().UTC().Add(processingLease))
+			if errors.Is(err, database.ErrNotFound) {
+				lost()
+				return
+			}
+			if err != nil {
+				log.Printf("Error extending lease on processing job %v: %v", job.ID, err)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func (cfg *apiConfig) processJob(ctx context.Context, job database.ProcessingJob) error {
+	_, err := cfg.videos.GetVideo(job.VideoID)
+	if errors.Is(err, database.ErrNotFound) {
+		return errVideoDeleted
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't get video: %w", err)
+	}
+	_, err = cfg.publishVideo(ctx, job.VideoID, job.SourcePath, func(state database.ProcessingState) {
+		if err := cfg.processingJobs.UpdateProcessingJobState(job.ID, job.Lease, state, nil); err != nil {
+			log.Printf("Error updating processing job %v: %v", job.ID, err)
+		}
+	})
+	return err
+}
+
+// recoverProcessingJobs runs at startup and then every processingLease.
+// Running jobs no worker holds a lease on any more, because the process
+// running them stopped, are queued again if their source file is still
+// spooled, and marked failed otherwise. Jobs other replicas are running are
+// left alone, and queued jobs are left for a worker to claim.
+func (cfg *apiConfig) recoverProcessingJobs() error {
+	now := time.Now().UTC()
+	jobs, err := cfg.processingJobs.GetAbandonedProcessingJobs(now)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		var failure string
+		if _, err := os.Stat(job.SourcePath); err != nil {
+			failure = "source file was lost during a server restart"
+		} else if job.Attempts >= maxProcessingAttempts {
+			failure = fmt.Sprintf("gave up after %d attempts", job.Attempts)
+		}
+
+		if failure != "" {
+			err = cfg.processingJobs.RecoverProcessingJob(job.ID, database.ProcessingStateFailed, &failure, now)
+			if err == nil {
+				os.Remove(job.SourcePath)
+			}
+		} else {
+			log.Printf("Resuming processing job %v for video %v", job.ID, job.VideoID)
+			err = cfg.processingJobs.RecoverProcessingJob(job.ID, database.ProcessingStateQueued, nil, now)
+		}
+		if errors.Is(err, database.ErrNotFound) {
+			// A worker got to it first.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
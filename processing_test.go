@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+func TestRunProcessingJobRetriesWithSource(t *testing.T) {
+	fake := &media.Fake{ProbeErr: errors.New("ffprobe: connection reset")}
+	cfg := newTestConfig(t, fake)
+	video := newTestVideo(t, cfg)
+
+	sourcePath := filepath.Join(cfg.processingSpoolDir, "upload.mp4")
+	if err := os.WriteFile(sourcePath, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.enqueueProcessing(video.ID, sourcePath); err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 1; attempt <= maxProcessingAttempts; attempt++ {
+		job, ok, err := cfg.processingJobs.ClaimProcessingJob(time.Now().UTC().Add(processingLease))
+		if err != nil || !ok {
+			t.Fatalf("attempt %d: couldn't claim job: ok %v, err %v", attempt, ok, err)
+		}
+		cfg.runProcessingJob(job)
+
+		job, err = cfg.processingJobs.GetProcessingJob(job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, statErr := os.Stat(sourcePath)
+		if attempt < maxProcessingAttempts {
+			if job.State != database.ProcessingStateQueued {
+				t.Errorf("attempt %d: job is %s, want queued for a retry", attempt, job.State)
+			}
+			if statErr != nil {
+				t.Errorf("attempt %d: source was removed before the last attempt", attempt)
+			}
+			continue
+		}
+		if job.State != database.ProcessingStateFailed || job.Error == nil {
+			t.Errorf("last attempt: job is %s with error %v, want failed", job.State, job.Error)
+		}
+		if !errors.Is(statErr, os.ErrNotExist) {
+			t.Errorf("last attempt: source still exists (%v)", statErr)
+		}
+	}
+	if len(fake.Probes()) != maxProcessingAttempts {
+		t.Errorf("probed %d times, want %d", len(fake.Probes()), maxProcessingAttempts)
+	}
+}
+
+// Recovery only takes over jobs whose worker stopped renewing its lease.
+func TestRecoverProcessingJobsLeavesLeasedJobs(t *testing.T) {
+	cfg := newTestConfig(t, &media.Fake{})
+	// claim queues a job and claims it with a lease until lockedUntil.
+	claim := func(name string, lockedUntil time.Time) database.ProcessingJob {
+		t.Helper()
+		sourcePath := filepath.Join(cfg.processingSpoolDir, name)
+		if err := os.WriteFile(sourcePath, []byte("video"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		queued, err := cfg.enqueueProcessing(newTestVideo(t, cfg).ID, sourcePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job, ok, err := cfg.processingJobs.ClaimProcessingJob(lockedUntil)
+		if err != nil || !ok || job.ID != queued.ID {
+			t.Fatalf("claimed %v (ok %v, err %v), want %v", job.ID, ok, err, queued.ID)
+		}
+		return job
+	}
+	running := claim("running.mp4", time.Now().UTC().Add(processingLease))
+	abandoned := claim("abandoned.mp4", time.Now().UTC().Add(-time.Minute))
+	// A queued job is a worker's to claim, even with its source gone.
+	queued, err := cfg.enqueueProcessing(newTestVideo(t, cfg).ID, filepath.Join(cfg.processingSpoolDir, "missing.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cfg.recoverProcessingJobs(); err != nil {
+		t.Fatal(err)
+	}
+	running, err = cfg.processingJobs.GetProcessingJob(running.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running.State != database.ProcessingStateProbing {
+		t.Errorf("leased job is %s, want it left probing", running.State)
+	}
+	abandoned, err = cfg.processingJobs.GetProcessingJob(abandoned.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if abandoned.State != database.ProcessingStateQueued {
+		t.Errorf("abandoned job is %s, want queued again", abandoned.State)
+	}
+	queued, err = cfg.processingJobs.GetProcessingJob(queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued.State != database.ProcessingStateQueued || queued.Error != nil {
+		t.Errorf("queued job is %s with error %v, want it left queued", queued.State, queued.Error)
+	}
+}
+
+// A worker whose lease was taken over leaves the job to its new owner.
+func TestRunProcessingJobAfterLosingLease(t *testing.T) {
+	fake := &media.Fake{ProbeErr: errors.New("ffprobe: connection reset")}
+	cfg := newTestConfig(t, fake)
+	sourcePath := filepath.Join(cfg.processingSpoolDir, "upload.mp4")
+	if err := os.WriteFile(sourcePath, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.enqueueProcessing(newTestVideo(t, cfg).ID, sourcePath); err != nil {
+		t.Fatal(err)
+	}
+	stale, ok, err := cfg.processingJobs.ClaimProcessingJob(time.Now().UTC().Add(-time.Minute))
+	if err != nil || !ok {
+		t.Fatalf("couldn't claim job: ok %v, err %v", ok, err)
+	}
+	if err := cfg.recoverProcessingJobs(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := cfg.processingJobs.ClaimProcessingJob(time.Now().UTC().Add(processingLease)); err != nil || !ok {
+		t.Fatalf("couldn't claim the recovered job: ok %v, err %v", ok, err)
+	}
+
+	cfg.runProcessingJob(stale)
+	job, err := cfg.processingJobs.GetProcessingJob(stale.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != database.ProcessingStateProbing || job.Error != nil {
+		t.Errorf("job is %s with error %v, want the new owner's state left alone", job.State, job.Error)
+	}
+	if _, err := os.Stat(sourcePath); err != nil {
+		t.Errorf("source was removed from under the new owner: %v", err)
+	}
+}
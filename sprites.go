@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+const spriteVTTName = "thumbnails.vtt"
+
+type spriteGrid struct {
+	Columns int
+	Rows    int
+}
+
+// parseSpriteGrid parses a grid such as "10x10" (columns x rows).
+func parseSpriteGrid(spec string) (spriteGrid, error) {
+	colsStr, rowsStr, ok := strings.Cut(strings.ToLower(strings.TrimSpace(spec)), "x")
+	if !ok {
+		return spriteGrid{}, fmt.Errorf("invalid sprite grid %q, want <columns>x<rows>", spec)
+	}
+	cols, err := strconv.Atoi(colsStr)
+	if err != nil || cols <= 0 {
+		return spriteGrid{}, fmt.Errorf("invalid sprite grid columns %q", colsStr)
+	}
+	rows, err := strconv.Atoi(rowsStr)
+	if err != nil || rows <= 0 {
+		return spriteGrid{}, fmt.Errorf("invalid sprite grid rows %q", rowsStr)
+	}
+	return spriteGrid{Columns: cols, Rows: rows}, nil
+}
+
+// spriteOutput is a rendered preview track on local disk. Sheets and VTT
+// are file names relative to Dir.
+type spriteOutput struct {
+	Dir        string
+	Sheets     []string
+	VTT        string
+	TileWidth  int
+	TileHeight int
+}
+
+// buildSprites samples a frame every cfg.spriteInterval, tiles the frames
+// into sprite sheets and writes the WebVTT track pointing into them. It
+// returns a zero spriteOutput when previews are disabled. The caller owns
+// the directory.
+func (cfg *apiConfig) buildSprites(ctx context.Context, filePath string, videoMetaData media.Metadata) (spriteOutput, error) {
+	if cfg.spriteInterval <= 0 {
+		return spriteOutput{}, nil
+	}
+	width, height, _ := videoDimensions(videoMetaData)
+	duration := videoDuration(videoMetaData)
+	if width == 0 || height == 0 || duration <= 0 {
+		return spriteOutput{}, fmt.Errorf("couldn't determine source resolution and duration")
+	}
+	tileWidth := cfg.spriteTileWidth
+	tileHeight := max(2, (tileWidth*height/width)&^1)
+
+	outDir, err := os.MkdirTemp(cfg.processingSpoolDir, "sprites-*")
+	if err != nil {
+		return spriteOutput{}, err
+	}
+	interval := cfg.spriteInterval.Seconds()
+	filter := fmt.Sprintf("fps=1/%s,scale=%d:%d,tile=%dx%d",
+		strconv.FormatFloat(interval, 'f', -1, 64),
+		tileWidth, tileHeight,
+		cfg.spriteGrid.Columns, cfg.spriteGrid.Rows,
+	)
+	err = cfg.transcoder.Run(ctx, []string{
+		"-v", "error",
+		"-i", filePath,
+		"-map", "0:v:0",
+		"-vf", filter,
+		"-q:v", "4",
+		"-f", "image2",
+		filepath.Join(outDir, "sprite_%03d.jpg"),
+	}, nil, nil)
+	if err != nil {
+		os.RemoveAll(outDir)
+		return spriteOutput{}, fmt.Errorf("sprite generation failed: %w", err)
+	}
+
+	out := spriteOutput{Dir: outDir, VTT: spriteVTTName, TileWidth: tileWidth, TileHeight: tileHeight}
+	sheets, err := filepath.Glob(filepath.Join(outDir, "sprite_*.jpg"))
+	if err != nil {
+		os.RemoveAll(outDir)
+		return spriteOutput{}, err
+	}
+	for _, sheet := range sheets {
+		out.Sheets = append(out.Sheets, filepath.Base(sheet))
+	}
+
+	vtt := spriteVTT(duration, interval, cfg.spriteGrid, tileWidth, tileHeight, out.Sheets)
+	err = os.WriteFile(filepath.Join(outDir, spriteVTTName), []byte(vtt), 0644)
+	if err != nil {
+		os.RemoveAll(outDir)
+		return spriteOutput{}, err
+	}
+	return out, nil
+}
+
+// spriteVTT writes one cue per sampled frame. Sheet names are relative so
+// they resolve against wherever the VTT file itself is served from.
+func spriteVTT(duration, interval float64, grid spriteGrid, tileWidth, tileHeight int, sheets []string) string {
+	perSheet := grid.Columns * grid.Rows
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i := 0; float64(i)*interval < duration; i++ {
+		sheet := i / perSheet
+		if sheet >= len(sheets) {
+			break
+		}
+		tile := i % perSheet
+		start := float64(i) * interval
+		end := min(start+interval, duration)
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end),
+			sheets[sheet],
+			(tile%grid.Columns)*tileWidth, (tile/grid.Columns)*tileHeight, tileWidth, tileHeight,
+		)
+	}
+	return b.String()
+}
+
+func vttTimestamp(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d",
+		int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60, d.Milliseconds()%1000)
+}
+
+// previewSpritesFor points the rendered track at where putDir uploaded it.
+func (cfg *apiConfig) previewSpritesFor(out spriteOutput, keyPrefix string) *database.PreviewSprites {
+	previews := &database.PreviewSprites{
+		VTTURL:     cfg.videoStore.URL(keyPrefix + "/" + out.VTT),
+		SheetURLs:  []string{},
+		Interval:   cfg.spriteInterval.Seconds(),
+		Columns:    cfg.spriteGrid.Columns,
+		Rows:       cfg.spriteGrid.Rows,
+		TileWidth:  out.TileWidth,
+		TileHeight: out.TileHeight,
+	}
+	for _, sheet := range out.Sheets {
+		previews.SheetURLs = append(previews.SheetURLs, cfg.videoStore.URL(keyPrefix+"/"+sheet))
+	}
+	return previews
+}
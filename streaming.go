@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+const streamingSegmentSeconds = 6
+
+type streamingFormats struct {
+	HLS  bool
+	DASH bool
+}
+
+func (f streamingFormats) Enabled() bool {
+	return f.HLS || f.DASH
+}
+
+// parseStreamingFormats parses a comma separated subset of "hls" and
+// "dash". "none" or an empty list disables adaptive streaming output.
+func parseStreamingFormats(spec string) (streamingFormats, error) {
+	formats := streamingFormats{}
+	for _, field := range strings.Split(spec, ",") {
+		switch strings.ToLower(strings.TrimSpace(field)) {
+		case "hls":
+			formats.HLS = true
+		case "dash":
+			formats.DASH = true
+		case "", "none":
+		default:
+			return streamingFormats{}, fmt.Errorf("unknown streaming format %q", field)
+		}
+	}
+	return formats, nil
+}
+
+// streamingRendition is one rung of the bitrate ladder. Size is the length of the
+// short side, so 720 means 1280x720 for landscape and 720x1280 for portrait
+// sources.
+type streamingRendition struct {
+	Size         int
+	VideoBitrate int // kbit/s
+	AudioBitrate int // kbit/s
+}
+
+var defaultRenditionBitrates = map[int]int{
+	2160: 14000,
+	1440: 9000,
+	1080: 5000,
+	720:  2800,
+	480:  1400,
+	360:  800,
+	240:  400,
+}
+
+// parseRenditions parses a ladder such as "1080,720:2500,480". Sizes
+// without an explicit bitrate use defaultRenditionBitrates.
+func parseRenditions(spec string) ([]streamingRendition, error) {
+	renditions := []streamingRendition{}
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		sizeStr, bitrateStr, hasBitrate := strings.Cut(field, ":")
+		size, err := strconv.Atoi(strings.TrimSuffix(sizeStr, "p"))
+		if err != nil || size <= 0 || size%2 != 0 {
+			return nil, fmt.Errorf("invalid rendition size %q", sizeStr)
+		}
+		bitrate, ok := defaultRenditionBitrates[size]
+		if hasBitrate {
+			bitrate, err = strconv.Atoi(strings.TrimSuffix(bitrateStr, "k"))
+			if err != nil || bitrate <= 0 {
+				return nil, fmt.Errorf("invalid rendition bitrate %q", bitrateStr)
+			}
+		} else if !ok {
+			return nil, fmt.Errorf("no default bitrate for %dp, use %d:<kbps>", size, size)
+		}
+		renditions = append(renditions, streamingRendition{
+			Size:         size,
+			VideoBitrate: bitrate,
+			AudioBitrate: 128,
+		})
+	}
+	sort.Slice(renditions, func(i, j int) bool {
+		return renditions[i].Size > renditions[j].Size
+	})
+	return renditions, nil
+}
+
+// ladderFor drops the renditions that would upscale the source. A source
+// smaller than every rung still gets a single rendition at its own size.
+func ladderFor(renditions []streamingRendition, width, height int) []streamingRendition {
+	shortSide := min(width, height)
+	ladder := []streamingRendition{}
+	for _, rendition := range renditions {
+		if rendition.Size <= shortSide {
+			ladder = append(ladder, rendition)
+		}
+	}
+	if len(ladder) == 0 && len(renditions) > 0 {
+		smallest := renditions[len(renditions)-1]
+		smallest.Size = shortSide - shortSide%2
+		ladder = append(ladder, smallest)
+	}
+	return ladder
+}
+
+// scaleFilter scales the short side to size, keeping the aspect ratio and
+// an even long side as libx264 requires.
+func scaleFilter(size int, portrait bool) string {
+	if portrait {
+		return fmt.Sprintf("scale=%d:-2", size)
+	}
+	return fmt.Sprintf("scale=-2:%d", size)
+}
+
+// renditionArgs builds the filter graph and per-rendition video encoder
+// options shared by the HLS and DASH packagers. Output video stream i is
+// rendition i of ladder.
+func renditionArgs(filePath string, ladder []streamingRendition, portrait bool) []string {
+	var filter strings.Builder
+	fmt.Fprintf(&filter, "[0:v]split=%d", len(ladder))
+	for i := range ladder {
+		fmt.Fprintf(&filter, "[v%d]", i)
+	}
+	for i, rendition := range ladder {
+		fmt.Fprintf(&filter, ";[v%d]%s[v%dout]", i, scaleFilter(rendition.Size, portrait), i)
+	}
+
+	args := []string{"-v", "error", "-i", filePath, "-filter_complex", filter.String()}
+	for i, rendition := range ladder {
+		args = append(args,
+			"-map", fmt.Sprintf("[v%dout]", i),
+			fmt.Sprintf("-c:v:%d", i), "libx264",
+			fmt.Sprintf("-b:v:%d", i), fmt.Sprintf("%dk", rendition.VideoBitrate),
+			fmt.Sprintf("-maxrate:v:%d", i), fmt.Sprintf("%dk", rendition.VideoBitrate*107/100),
+			fmt.Sprintf("-bufsize:v:%d", i), fmt.Sprintf("%dk", rendition.VideoBitrate*3/2),
+		)
+	}
+	return append(args,
+		"-preset", "veryfast",
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", streamingSegmentSeconds),
+	)
+}
+
+// packageHLS transcodes filePath into every rendition of ladder in one
+// ffmpeg run and writes the segments, variant playlists and master.m3u8
+// into outDir.
+func packageHLS(ctx context.Context, t media.Transcoder, filePath, outDir string, ladder []streamingRendition, portrait, hasAudio bool) error {
+	args := renditionArgs(filePath, ladder, portrait)
+	streamMap := []string{}
+	for i, rendition := range ladder {
+		entry := fmt.Sprintf("v:%d,name:%dp", i, rendition.Size)
+		if hasAudio {
+			// The HLS muxer pairs every variant with its own audio copy.
+			args = append(args,
+				"-map", "0:a:0",
+				fmt.Sprintf("-c:a:%d", i), "aac",
+				fmt.Sprintf("-b:a:%d", i), fmt.Sprintf("%dk", rendition.AudioBitrate),
+			)
+			entry = fmt.Sprintf("v:%d,a:%d,name:%dp", i, i, rendition.Size)
+		}
+		streamMap = append(streamMap, entry)
+	}
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(streamingSegmentSeconds),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(outDir, "%v", "segment_%04d.ts"),
+		"-master_pl_name", hlsMasterPlaylist,
+		"-var_stream_map", strings.Join(streamMap, " "),
+		filepath.Join(outDir, "%v", "index.m3u8"),
+	)
+	if err := t.Run(ctx, args, nil, nil); err != nil {
+		return fmt.Errorf("hls packaging failed: %w", err)
+	}
+	return nil
+}
+
+// packageCMAF transcodes filePath into fMP4 (CMAF) segments described by a
+// DASH manifest and, when withHLS is set, an HLS master playlist that
+// references the very same segments.
+func packageCMAF(ctx context.Context, t media.Transcoder, filePath, outDir string, ladder []streamingRendition, portrait, hasAudio, withHLS bool) error {
+	args := renditionArgs(filePath, ladder, portrait)
+	adaptationSets := "id=0,streams=v"
+	if hasAudio {
+		args = append(args,
+			"-map", "0:a:0",
+			"-c:a", "aac",
+			"-b:a", fmt.Sprintf("%dk", ladder[0].AudioBitrate),
+		)
+		adaptationSets += " id=1,streams=a"
+	}
+	args = append(args,
+		"-f", "dash",
+		"-seg_duration", strconv.Itoa(streamingSegmentSeconds),
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-dash_segment_type", "mp4",
+		"-adaptation_sets", adaptationSets,
+		"-init_seg_name", "init-$RepresentationID$.m4s",
+		"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+	)
+	if withHLS {
+		args = append(args, "-hls_playlist", "1")
+	}
+	args = append(args, filepath.Join(outDir, dashManifest))
+	if err := t.Run(ctx, args, nil, nil); err != nil {
+		return fmt.Errorf("cmaf packaging failed: %w", err)
+	}
+	return nil
+}
+
+const (
+	hlsMasterPlaylist = "master.m3u8"
+	dashManifest      = "manifest.mpd"
+)
+
+// streamingOutput is a packaged ladder on local disk. HLSPath and DASHPath
+// are relative to Dir and empty for formats that were not produced.
+type streamingOutput struct {
+	Dir      string
+	HLSPath  string
+	DASHPath string
+}
+
+// buildStreaming packages the source into the configured ladder and
+// formats inside a new directory under the processing spool. The caller
+// owns the directory. It returns a zero streamingOutput when adaptive
+// streaming is disabled.
+//
+// HLS on its own uses MPEG-TS segments for the widest player support. As
+// soon as DASH is requested everything is packaged once as CMAF so both
+// manifests share one set of segments.
+func (cfg *apiConfig) buildStreaming(ctx context.Context, filePath string, videoMetaData media.Metadata) (streamingOutput, error) {
+	if !cfg.streamingFormats.Enabled() || len(cfg.streamingRenditions) == 0 {
+		return streamingOutput{}, nil
+	}
+	width, height, hasAudio := videoDimensions(videoMetaData)
+	if width == 0 || height == 0 {
+		return streamingOutput{}, fmt.Errorf("couldn't determine source resolution")
+	}
+	ladder := ladderFor(cfg.streamingRenditions, width, height)
+	portrait := height > width
+
+	outDir, err := os.MkdirTemp(cfg.processingSpoolDir, "stream-*")
+	if err != nil {
+		return streamingOutput{}, err
+	}
+	out := streamingOutput{Dir: outDir}
+	if cfg.streamingFormats.DASH {
+		err = packageCMAF(ctx, cfg.transcoder, filePath, outDir, ladder, portrait, hasAudio, cfg.streamingFormats.HLS)
+		out.DASHPath = dashManifest
+	} else {
+		err = packageHLS(ctx, cfg.transcoder, filePath, outDir, ladder, portrait, hasAudio)
+	}
+	if err != nil {
+		os.RemoveAll(outDir)
+		return streamingOutput{}, err
+	}
+	if cfg.streamingFormats.HLS {
+		out.HLSPath = hlsMasterPlaylist
+	}
+	return out, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/thumbnails"
+	"github.com/google/uuid"
+)
+
+const (
+	thumbnailSceneThreshold = 0.3
+	// Frames where at least this percentage of pixels is black are skipped.
+	thumbnailMaxBlackPercent = 90
+	// Scene detection decodes every frame, so it only looks at the start.
+	thumbnailSceneWindow = 5 * time.Minute
+)
+
+var errNoFrameAt = errors.New("no frame at that timestamp")
+
+var notBlackFilter = fmt.Sprintf(
+	"blackframe=amount=0:threshold=32,metadata=select:key=lavfi.blackframe.pblack:value=%d:function=less",
+	thumbnailMaxBlackPercent,
+)
+
+type frameQuery struct {
+	start  time.Duration
+	window time.Duration
+	filter string
+}
+
+// extractFrame writes the first frame of input at or after q.start that gets
+// through q.filter to outPath as a PNG. It reports false when no frame qualified.
+func extractFrame(ctx context.Context, t media.Transcoder, input, outPath string, q frameQuery) (bool, error) {
+	os.Remove(outPath)
+	args := []string{"-v", "error", "-y"}
+	if q.start > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(q.start.Seconds(), 'f', 3, 64))
+	}
+	if q.window > 0 {
+		args = append(args, "-t", strconv.FormatFloat(q.window.Seconds(), 'f', 3, 64))
+	}
+	args = append(args, "-i", input, "-map", "0:v:0")
+	if q.filter != "" {
+		args = append(args, "-vf", q.filter)
+	}
+	args = append(args, "-frames:v", "1", "-update", "1", "-c:v", "png", outPath)
+	if err := t.Run(ctx, args, nil, nil); err != nil {
+		return false, fmt.Errorf("frame extraction failed: %w", err)
+	}
+
+	info, err := os.Stat(outPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return info.Size() > 0, nil
+}
+
+// extractThumbnail picks a representative frame: the first scene change that
+// isn't mostly black, then the first non-black frame after fallbackOffset,
+// then whatever frame the video starts with.
+func extractThumbnail(ctx context.Context, t media.Transcoder, input, outPath string, fallbackOffset time.Duration) error {
+	queries := []frameQuery{
+		{
+			window: thumbnailSceneWindow,
+			filter: fmt.Sprintf("select='gt(scene,%v)',%s", thumbnailSceneThreshold, notBlackFilter),
+		},
+		{start: fallbackOffset, filter: notBlackFilter},
+		{},
+	}
+	for _, q := range queries {
+		ok, err := extractFrame(ctx, t, input, outPath, q)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.New("video has no frames to use as a thumbnail")
+}
+
+// generateThumbnail extracts a frame from input and stores it in every
+// thumbnail size and format. A negative at picks the frame automatically,
+// otherwise the frame at that timestamp is used as is, failing with
+// errNoFrameAt when at is past the end of the video.
+func (cfg *apiConfig) generateThumbnail(ctx context.Context, input string, at time.Duration) (storedThumbnail, error) {
+	outPath := filepath.Join(cfg.processingSpoolDir, "thumbnail-"+uuid.NewString()+".png")
+	defer os.Remove(outPath)
+
+	if at < 0 {
+		err := extractThumbnail(ctx, cfg.transcoder, input, outPath, cfg.thumbnailOffset)
+		if err != nil {
+			return storedThumbnail{}, err
+		}
+	} else {
+		ok, err := extractFrame(ctx, cfg.transcoder, input, outPath, frameQuery{start: at})
+		if err != nil {
+			return storedThumbnail{}, err
+		}
+		if !ok {
+			return storedThumbnail{}, errNoFrameAt
+		}
+	}
+
+	data, err := os.ReadFile(outPath)
+	if err != nil {
+		return storedThumbnail{}, err
+	}
+	img, _, err := thumbnails.Decode(data)
+	if err != nil {
+		return storedThumbnail{}, err
+	}
+	return cfg.storeThumbnailImage(ctx, img)
+}
+
+// videoSourceURL returns a URL ffmpeg can read the stored video from. Stores
+// that can presign hand out a short-lived GET URL so private buckets work.
+func (cfg *apiConfig) videoSourceURL(ctx context.Context, videoURL string) (string, error) {
+	key, ok := strings.CutPrefix(videoURL, cfg.videoStore.URL(""))
+	if !ok {
+		return videoURL, nil
+	}
+	presigner, ok := cfg.videoStore.(storage.Presigner)
+	if !ok {
+		return videoURL, nil
+	}
+	return presigner.PresignGet(ctx, key, cfg.directUploadTTL)
+}
+
+// autoThumbnail is the best-effort thumbnail step of the processing pipeline.
+// It only runs while the video has no thumbnail of the owner's choosing and
+// returns the stored frame, or a zero storedThumbnail if it was skipped or
+// failed.
+func (cfg *apiConfig) autoThumbnail(ctx context.Context, videoID uuid.UUID, srcPath string) storedThumbnail {
+	if !cfg.thumbnailAuto {
+		return storedThumbnail{}
+	}
+	video, err := cfg.videos.GetVideo(videoID)
+	if err != nil {
+		log.Printf("Error getting video %v for thumbnail extraction: %v", videoID, err)
+		return storedThumbnail{}
+	}
+	if video.ThumbnailURL != nil && !video.ThumbnailGenerated {
+		return storedThumbnail{}
+	}
+	stored, err := cfg.generateThumbnail(ctx, srcPath, -1)
+	if err != nil {
+		log.Printf("Error extracting thumbnail for video %v: %v", videoID, err)
+		return storedThumbnail{}
+	}
+	return stored
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+// videoLimits bounds what uploads are accepted. Zero values don't limit.
+type videoLimits struct {
+	MaxDuration time.Duration
+	// MaxLongSide and MaxShortSide come from a resolution like 3840x2160
+	// and apply to portrait videos the other way round.
+	MaxLongSide  int
+	MaxShortSide int
+	MaxBitRate   int64 // bit/s
+	// Containers are the sniffed container names that are accepted. All of
+	// them are normalized to MP4 during processing.
+	Containers map[string]bool
+}
+
+// parseResolution parses a resolution such as "3840x2160".
+func parseResolution(spec string) (width, height int, err error) {
+	widthStr, heightStr, ok := strings.Cut(strings.ToLower(strings.TrimSpace(spec)), "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid resolution %q, want <width>x<height>", spec)
+	}
+	width, err = strconv.Atoi(widthStr)
+	if err != nil || width <= 0 {
+		return 0, 0, fmt.Errorf("invalid resolution width %q", widthStr)
+	}
+	height, err = strconv.Atoi(heightStr)
+	if err != nil || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid resolution height %q", heightStr)
+	}
+	return width, height, nil
+}
+
+func parseContainers(spec string) map[string]bool {
+	containers := map[string]bool{}
+	for _, name := range strings.Split(spec, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			containers[name] = true
+		}
+	}
+	return containers
+}
+
+// validationError explains why an upload was rejected. Reason is a stable
+// machine-readable code; Limit and Actual are set for limit violations.
+type validationError struct {
+	Status  int    `json:"-"`
+	Message string `json:"error"`
+	Reason  string `json:"reason"`
+	Limit   any    `json:"limit,omitempty"`
+	Actual  any    `json:"actual,omitempty"`
+}
+
+func (e *validationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reason, e.Message)
+}
+
+func respondWithValidationError(w http.ResponseWriter, verr *validationError) {
+	respondWithJSON(w, verr.Status, verr)
+}
+
+// sniffVideoContainer reads the start of r and checks it is an allowed
+// container.
+func sniffVideoContainer(r io.Reader, allowed map[string]bool) (string, *validationError, error) {
+	header := make([]byte, media.SniffHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", nil, err
+	}
+	container := media.SniffContainer(header[:n])
+	if container == "" {
+		return "", &validationError{
+			Status:  http.StatusUnsupportedMediaType,
+			Reason:  "unrecognized_format",
+			Message: "File is not a recognized video container",
+		}, nil
+	}
+	if !allowed[container] {
+		return "", &validationError{
+			Status:  http.StatusUnsupportedMediaType,
+			Reason:  "container_not_allowed",
+			Message: fmt.Sprintf("%s files are not accepted", strings.ToUpper(container)),
+			Actual:  container,
+		}, nil
+	}
+	return container, nil, nil
+}
+
+// checkVideoMetadata enforces that the probed file has a decodable video
+// stream within the configured limits.
+func checkVideoMetadata(videoMetaData media.Metadata, limits videoLimits) *validationError {
+	index, ok := primaryVideoStream(videoMetaData)
+	if !ok || videoMetaData.Streams[index].CodecName == "" {
+		return &validationError{
+			Status:  http.StatusUnprocessableEntity,
+			Reason:  "no_video_stream",
+			Message: "File has no decodable video stream",
+		}
+	}
+
+	duration := videoDuration(videoMetaData)
+	if limits.MaxDuration > 0 && duration > limits.MaxDuration.Seconds() {
+		return &validationError{
+			Status:  http.StatusUnprocessableEntity,
+			Reason:  "duration_too_long",
+			Message: fmt.Sprintf("Video can't be longer than %v", limits.MaxDuration),
+			Limit:   limits.MaxDuration.Seconds(),
+			Actual:  duration,
+		}
+	}
+
+	width, height := displaySize(videoMetaData, index)
+	longSide, shortSide := max(width, height), min(width, height)
+	if (limits.MaxLongSide > 0 && longSide > limits.MaxLongSide) || (limits.MaxShortSide > 0 && shortSide > limits.MaxShortSide) {
+		return &validationError{
+			Status:  http.StatusUnprocessableEntity,
+			Reason:  "resolution_too_high",
+			Message: fmt.Sprintf("Video resolution can't exceed %dx%d", limits.MaxLongSide, limits.MaxShortSide),
+			Limit:   fmt.Sprintf("%dx%d", limits.MaxLongSide, limits.MaxShortSide),
+			Actual:  fmt.Sprintf("%dx%d", width, height),
+		}
+	}
+
+	bitRate, _ := strconv.ParseInt(videoMetaData.Format.BitRate, 10, 64)
+	if limits.MaxBitRate > 0 && bitRate > limits.MaxBitRate {
+		return &validationError{
+			Status:  http.StatusUnprocessableEntity,
+			Reason:  "bitrate_too_high",
+			Message: fmt.Sprintf("Video bitrate can't exceed %d kbit/s", limits.MaxBitRate/1000),
+			Limit:   limits.MaxBitRate,
+			Actual:  bitRate,
+		}
+	}
+	return nil
+}
+
+// validateVideoFile sniffs and probes the upload at path. A non-nil
+// validationError means the upload should be rejected; err is reserved for
+// failures on our side.
+func (cfg *apiConfig) validateVideoFile(ctx context.Context, path string) (*validationError, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	_, verr, err := sniffVideoContainer(file, cfg.videoLimits.Containers)
+	file.Close()
+	if verr != nil || err != nil {
+		return verr, err
+	}
+	return cfg.probeAndCheck(ctx, path)
+}
+
+func (cfg *apiConfig) probeAndCheck(ctx context.Context, input string) (*validationError, error) {
+	videoMetaData, err := cfg.prober.Probe(ctx, input)
+	if errors.Is(err, media.ErrUnreadable) {
+		return &validationError{
+			Status:  http.StatusUnprocessableEntity,
+			Reason:  "undecodable",
+			Message: "Video couldn't be read",
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return checkVideoMetadata(videoMetaData, cfg.videoLimits), nil
+}
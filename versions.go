@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) versionRetentionPolicy() database.VersionRetention {
+	return database.VersionRetention{
+		Until: time.Now().Add(cfg.versionRetention),
+		Keep:  cfg.versionLimit,
+	}
+}
+
+// replaceVideo saves video, which has been pointed at a newly uploaded file,
+// over previous. The old file is kept as a version for cfg.versionRetention
+// and expired by the cleanup worker. If saving fails the old file is left
+// alone and the caller must roll back the new content.
+func (cfg *apiConfig) replaceVideo(previous, video database.Video) error {
+	err := cfg.versions.ReplaceVideo(previous, video, cfg.versionRetentionPolicy())
+	if err != nil {
+		return err
+	}
+	cfg.wakeCleanup()
+	return nil
+}
+
+// expireVideoVersions drops every retained version whose retention window
+// has passed.
+func (cfg *apiConfig) expireVideoVersions() {
+	expired := false
+	for {
+		version, ok, err := cfg.versions.ExpireVideoVersion(time.Now(), videoObjectCleanup)
+		if err != nil {
+			log.Printf("Error expiring video versions: %v", err)
+			break
+		}
+		if !ok {
+			break
+		}
+		log.Printf("Version %v of video %v expired", version.ID, version.VideoID)
+		expired = true
+	}
+	if expired {
+		cfg.wakeCleanup()
+	}
+}
+
+func (cfg *apiConfig) handlerVideoVersionsGet(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.videos.GetVideo(videoID)
+	if err != nil {
+		respondWithDBError(w, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't view this video's versions", nil)
+		return
+	}
+
+	versions, err := cfg.versions.GetVideoVersions(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video versions", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, versions)
+}
+
+func (cfg *apiConfig) handlerVideoVersionPromote(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	versionID, err := uuid.Parse(r.PathValue("versionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid version ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.videos.GetVideo(videoID)
+	if err != nil {
+		respondWithDBError(w, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't change this video's versions", nil)
+		return
+	}
+
+	version, err := cfg.versions.GetVideoVersion(versionID)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video version", err)
+		return
+	}
+	if err != nil || version.VideoID != videoID {
+		respondWithError(w, http.StatusNotFound, "Version not found", nil)
+		return
+	}
+	if version.Current {
+		respondWithError(w, http.StatusConflict, "Version is already current", nil)
+		return
+	}
+
+	previous := video
+	video.VideoURL = version.VideoURL
+	video.HLSURL = version.HLSURL
+	video.DashURL = version.DashURL
+	video.PreviewSprites = version.PreviewSprites
+	video.ContentHash = version.ContentHash
+	video.VideoKey = version.VideoKey
+	video.Metadata = version.Metadata
+	err = cfg.versions.PromoteVideoVersion(previous, video, versionID, cfg.versionRetentionPolicy())
+	if errors.Is(err, database.ErrVersionExpired) {
+		respondWithError(w, http.StatusNotFound, "Version has expired", err)
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, "Couldn't promote video version", err)
+		return
+	}
+	cfg.wakeCleanup()
+
+	video, err = cfg.videos.GetVideo(videoID)
+	if err != nil {
+		respondWithDBError(w, "Error getting video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+// technicalMetadata flattens ffprobe output into what we persist. The
+// primary video stream and the first audio stream describe the file.
+func technicalMetadata(videoMetaData media.Metadata) database.VideoMetadata {
+	m := database.VideoMetadata{
+		Duration:  videoDuration(videoMetaData),
+		Container: containerName(videoMetaData.Format.FormatName),
+	}
+	m.BitRate, _ = strconv.ParseInt(videoMetaData.Format.BitRate, 10, 64)
+
+	if index, ok := primaryVideoStream(videoMetaData); ok {
+		stream := videoMetaData.Streams[index]
+		m.Width, m.Height = stream.Width, stream.Height
+		m.VideoCodec = stream.CodecName
+		m.FrameRate = parseFrameRate(stream.AvgFrameRate)
+		if m.FrameRate == 0 {
+			m.FrameRate = parseFrameRate(stream.RFrameRate)
+		}
+		m.Rotation = streamRotation(stream.Tags.Rotate, stream.SideDataList)
+	}
+	for _, stream := range videoMetaData.Streams {
+		if stream.CodecType == "audio" {
+			m.AudioCodec = stream.CodecName
+			m.AudioChannels = stream.Channels
+			m.AudioSampleRate, _ = strconv.Atoi(stream.SampleRate)
+			break
+		}
+	}
+	return m
+}
+
+// videoDuration returns the duration in seconds from the container, falling
+// back to the primary video stream, or 0 if ffprobe reported neither.
+func videoDuration(videoMetaData media.Metadata) float64 {
+	if duration, err := strconv.ParseFloat(videoMetaData.Format.Duration, 64); err == nil {
+		return duration
+	}
+	if index, ok := primaryVideoStream(videoMetaData); ok {
+		if duration, err := strconv.ParseFloat(videoMetaData.Streams[index].Duration, 64); err == nil {
+			return duration
+		}
+	}
+	return 0
+}
+
+// parseFrameRate parses ffprobe's rational frame rates such as "30000/1001".
+func parseFrameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !ok {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// streamRotation returns the clockwise display rotation in degrees,
+// normalised to 0, 90, 180 or 270. Newer ffprobe versions report it as a
+// display matrix side data entry (counter-clockwise), older ones as a
+// "rotate" tag (clockwise).
+func streamRotation(rotateTag string, sideData []media.SideData) int {
+	rotation := 0
+	if tag, err := strconv.Atoi(rotateTag); err == nil {
+		rotation = tag
+	}
+	for _, entry := range sideData {
+		if entry.SideDataType == "Display Matrix" {
+			rotation = -entry.Rotation
+		}
+	}
+	return ((rotation % 360) + 360) % 360
+}
+
+// containerName shortens ffprobe's demuxer list to the common name of the
+// container, e.g. "mov,mp4,m4a,3gp,3g2,mj2" becomes "mp4".
+func containerName(formatName string) string {
+	names := strings.Split(formatName, ",")
+	for _, name := range names {
+		switch name {
+		case "mp4":
+			return "mp4"
+		case "matroska":
+			return "mkv"
+		}
+	}
+	return names[0]
+}
+
+const (
+	defaultVideoPageSize = 50
+	maxVideoPageSize     = 100
+)
+
+// parseVideoQuery reads the filters, sort and page of GET /api/videos.
+// Titles sort A to Z by default and everything else newest or longest
+// first; order=asc or order=desc overrides that.
+func parseVideoQuery(query url.Values) (database.VideoQuery, error) {
+	filter, err := parseVideoFilter(query)
+	if err != nil {
+		return database.VideoQuery{}, err
+	}
+	q := database.VideoQuery{
+		Filter: filter,
+		Sort:   database.VideoSort(query.Get("sort")),
+		Limit:  defaultVideoPageSize,
+		Cursor: query.Get("cursor"),
+	}
+	switch q.Sort {
+	case "":
+		q.Sort = database.VideoSortCreated
+	case database.VideoSortCreated, database.VideoSortUpdated, database.VideoSortDuration:
+	case database.VideoSortTitle:
+		q.Ascending = true
+	default:
+		return database.VideoQuery{}, fmt.Errorf("invalid sort %q", q.Sort)
+	}
+	switch order := query.Get("order"); order {
+	case "":
+	case "asc":
+		q.Ascending = true
+	case "desc":
+		q.Ascending = false
+	default:
+		return database.VideoQuery{}, fmt.Errorf("invalid order %q", order)
+	}
+	if v := query.Get("limit"); v != "" {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil || q.Limit < 1 {
+			return database.VideoQuery{}, fmt.Errorf("invalid limit %q", v)
+		}
+		q.Limit = min(q.Limit, maxVideoPageSize)
+	}
+	return q, nil
+}
+
+// parseVideoFilter reads the filters of GET /api/videos.
+func parseVideoFilter(query url.Values) (database.VideoFilter, error) {
+	filter := database.VideoFilter{
+		VideoCodec: query.Get("video_codec"),
+		AudioCodec: query.Get("audio_codec"),
+		Container:  query.Get("container"),
+	}
+	floats := map[string]*float64{
+		"min_duration": &filter.MinDuration,
+		"max_duration": &filter.MaxDuration,
+	}
+	for name, dst := range floats {
+		if v := query.Get(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 {
+				return database.VideoFilter{}, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = f
+		}
+	}
+	ints := map[string]*int{
+		"min_width":  &filter.MinWidth,
+		"max_width":  &filter.MaxWidth,
+		"min_height": &filter.MinHeight,
+		"max_height": &filter.MaxHeight,
+	}
+	for name, dst := range ints {
+		if v := query.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return database.VideoFilter{}, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = n
+		}
+	}
+	bools := map[string]**bool{
+		"has_video":     &filter.HasVideo,
+		"has_thumbnail": &filter.HasThumbnail,
+	}
+	for name, dst := range bools {
+		if v := query.Get(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return database.VideoFilter{}, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = &b
+		}
+	}
+	times := map[string]*time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+	}
+	for name, dst := range times {
+		if v := query.Get(name); v != "" {
+			t, err := parseDateOrTime(v)
+			if err != nil {
+				return database.VideoFilter{}, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = t
+		}
+	}
+	return filter, nil
+}
+
+// parseDateOrTime accepts an RFC 3339 time or a bare date, which means
+// midnight UTC.
+func parseDateOrTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}